@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gwd/session-scheduler/event"
+)
+
+// benchmark runs search algorithms against synthetic events (see
+// event.TestPopulate), and prints the score, constraint violations and
+// runtime of each.  The searches use the options in the server
+// config, apart from the algorithm.
+func benchmark(args []string, location string) {
+	fs := flag.NewFlagSet("benchmark", flag.ExitOnError)
+	users := fs.Int("users", 50, "Number of users in each event")
+	discussions := fs.Int("discussions", 24, "Number of discussions in each event")
+	days := fs.Int("days", 2, "Number of days in each event")
+	slots := fs.Int("slots", 6, "Number of slots in each day")
+	locations := fs.Int("locations", 3, "Number of locations in each event")
+	events := fs.Int("events", 1, "Number of events to generate")
+	seed := fs.Int64("event-seed", 0, "Seed for the first event; the rest use the following seeds (default: chosen from the clock)")
+	algos := fs.String("algos", "heuristic,random,genetic,annealing,exact", "Comma-separated search algorithms to run")
+	fs.Parse(args)
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Seed\tAlgorithm\tScore\tMax\tMean %\tMin %\tIterations\tViolations\tWarnings\tRuntime\t")
+	for i := 0; i < *events; i++ {
+		topt := event.TestEventOptions{
+			Users:       *users,
+			Discussions: *discussions,
+			Days:        *days,
+			SlotsPerDay: *slots,
+			Locations:   *locations,
+			Seed:        *seed + int64(i),
+		}
+		if err := benchmarkEvent(w, topt, strings.Split(*algos, ","), location); err != nil {
+			// Keep the rows for the searches which did finish
+			w.Flush()
+			log.Fatalf("%v", err)
+		}
+	}
+	w.Flush()
+}
+
+// benchmarkEvent generates a synthetic event with topt, and writes a
+// row to w for each of algos run against it.  A search which fails
+// gets a row with its error; any other error stops the benchmark.
+func benchmarkEvent(w io.Writer, topt event.TestEventOptions, algos []string, location string) error {
+	cleanup, err := event.LoadTemporary(location)
+	if err != nil {
+		return fmt.Errorf("Creating synthetic event: %v", err)
+	}
+	defer cleanup()
+
+	if err := event.TestPopulate(topt); err != nil {
+		return fmt.Errorf("Generating synthetic event: %v", err)
+	}
+
+	for _, algo := range algos {
+		opt := getSearchOptions(false)
+		opt.Algo = event.SearchAlgo(strings.TrimSpace(algo))
+
+		start := time.Now()
+		if err := event.MakeSchedule(opt); err != nil {
+			fmt.Fprintf(w, "%d\t%s\t-\t-\t-\t-\t-\t-\t-\t-\t  %v\n", topt.Seed, opt.Algo, err)
+			continue
+		}
+		runtime := time.Since(start)
+
+		versions, err := event.ScheduleVersionGetAll()
+		if err == nil && len(versions) == 0 {
+			err = fmt.Errorf("No versions")
+		}
+		if err != nil {
+			return fmt.Errorf("Getting schedule version: %v", err)
+		}
+		dist, err := event.UtilityDistributionGet()
+		if err != nil {
+			return fmt.Errorf("Getting utility: %v", err)
+		}
+		violations, warnings, err := event.ValidateSchedule()
+		if err != nil {
+			return fmt.Errorf("Validating schedule: %v", err)
+		}
+		for _, v := range violations {
+			log.Printf("%s (seed %d): violation: %s", opt.Algo, topt.Seed, v)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%v\t\n",
+			topt.Seed, opt.Algo,
+			versions[0].Score, dist.MaxScore, dist.MeanPercent, dist.MinPercent,
+			versions[0].Iterations, len(violations), len(warnings),
+			runtime.Round(time.Millisecond))
+	}
+	return nil
+}
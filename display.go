@@ -23,6 +23,14 @@ type UserDisplay struct {
 	Profile     UserProfile
 	Description template.HTML // Sanitised description, suitable for displaying
 	List        []*DiscussionDisplay
+	// Report: How good the schedule is for this user.  Only
+	// generated for the user themselves and admins, since it reveals
+	// their interest.
+	Report *event.UserReport
+	// The slots and days the user isn't at the event, for the edit
+	// form.
+	UnavailableSlots []event.DisplaySlot
+	UnavailableDays  []event.DisplayDay
 }
 
 func UserGetDisplay(u *event.User, cur *event.User, long bool) (ud *UserDisplay) {
@@ -41,6 +49,18 @@ func UserGetDisplay(u *event.User, cur *event.User, long bool) (ud *UserDisplay)
 		ud.Profile.Company = u.Company
 		ud.Profile.Description = u.Description
 		ud.Description = ProcessText(u.Description)
+		if cur.IsAdmin || cur.UserID == u.UserID {
+			var err error
+			ud.Report, err = event.UserReportGet(u.UserID)
+			if err != nil {
+				// Report error but continue
+				log.Printf("INTERNAL ERROR: Getting report for user %v: %v", u.UserID, err)
+			}
+		}
+		if long && ud.MayEdit {
+			ud.UnavailableSlots = event.TimetableGetUnavailableSlots(u.UserID)
+			ud.UnavailableDays = event.TimetableGetUnavailableDays(u.UserID)
+		}
 	}
 	// But show discussions to everyone.  (This is already available
 	// from the 'sessions' list.)
@@ -61,17 +81,111 @@ type DiscussionDisplay struct {
 	// MayEdit: Used to determine whether to show edit / delete buttons
 	MayEdit bool
 	// IsAdmin: Used to determine whether to show slot scheduling options
-	IsAdmin       bool
-	Interest      int
-	Location      event.Location
-	Time          string
-	IsFinal       bool
+	IsAdmin  bool
+	Interest int
+	Location event.Location
+	Time     string
+	IsFinal  bool
+	Tags     []string
+	Length   int
+	// Lengths: The choices of length for the form
+	Lengths       []int
 	PossibleSlots []event.DisplaySlot
+	// IsPinned, PinSlots, PinLocations: Where an admin has pinned the
+	// discussion, and the choices for the form.  Only generated for
+	// admin user.
+	IsPinned     bool
+	PinSlots     []event.DisplaySlot
+	PinLocations []PinLocation
+	// Orders: The discussions this one must come before or after.
+	// OrderChoices: The other discussions, for the form.  Only
+	// generated if MayEdit.
+	Orders       []OrderDisplay
+	OrderChoices []event.Discussion
 	// AllUsers: Used to generate a dropdown for admins to change the
 	// owner.  Only geneated for admin user.
 	AllUsers []event.User
 }
 
+type PinLocation struct {
+	LocationName string
+	LocationID   event.LocationID
+	Checked      bool
+}
+
+// discussionPinLocations returns the places a discussion may be pinned
+// to, checked if pin is there.
+func discussionPinLocations(pin *event.DiscussionPin) (pl []PinLocation) {
+	locations, err := event.LocationGetAll()
+	if err != nil {
+		log.Printf("INTERNAL ERROR: Getting locations: %v", err)
+		return nil
+	}
+	for _, loc := range locations {
+		if !loc.IsPlace {
+			continue
+		}
+		pl = append(pl, PinLocation{
+			LocationName: loc.LocationName,
+			LocationID:   loc.LocationID,
+			Checked:      pin != nil && pin.LocationID == loc.LocationID,
+		})
+	}
+	return
+}
+
+type OrderDisplay struct {
+	event.DiscussionOrder
+	Other    *event.Discussion
+	IsBefore bool // Whether this discussion comes before Other
+}
+
+// discussionOrders returns the discussions d must come before or
+// after.
+func discussionOrders(d *event.Discussion) (od []OrderDisplay) {
+	orders, err := event.DiscussionGetOrders(d.DiscussionID)
+	if err != nil {
+		log.Printf("INTERNAL ERROR: Getting orders for discussion %v: %v", d.DiscussionID, err)
+		return nil
+	}
+	for _, o := range orders {
+		display := OrderDisplay{DiscussionOrder: o, IsBefore: o.BeforeID == d.DiscussionID}
+		otherID := o.BeforeID
+		if display.IsBefore {
+			otherID = o.AfterID
+		}
+		display.Other, err = event.DiscussionFindById(otherID)
+		if err != nil {
+			log.Printf("INTERNAL ERROR: Getting discussion %v: %v", otherID, err)
+			continue
+		}
+		od = append(od, display)
+	}
+	return
+}
+
+// discussionOrderChoices returns the discussions cur may order d
+// before or after: all the other discussions cur may see.
+func discussionOrderChoices(d *event.Discussion, cur *event.User) (choices []event.Discussion) {
+	event.DiscussionIterate(func(other *event.Discussion) error {
+		if other.DiscussionID != d.DiscussionID &&
+			(other.IsPublic || cur.MayEditDiscussion(other)) {
+			choices = append(choices, *other)
+		}
+		return nil
+	})
+	return
+}
+
+// discussionLengths returns the lengths a discussion may have, for
+// the form.
+func discussionLengths() (lengths []int) {
+	for l := 1; l <= event.MaxDiscussionLength; l++ {
+		lengths = append(lengths, l)
+	}
+	return
+}
+
 func DiscussionGetDisplay(d *event.Discussion, cur *event.User) *DiscussionDisplay {
 	showMain := true
 
@@ -90,6 +204,8 @@ func DiscussionGetDisplay(d *event.Discussion, cur *event.User) *DiscussionDispl
 	dd := &DiscussionDisplay{
 		DiscussionID: d.DiscussionID,
 		IsPublic:     d.IsPublic,
+		Length:       d.Length,
+		Lengths:      discussionLengths(),
 	}
 
 	if showMain {
@@ -106,6 +222,13 @@ func DiscussionGetDisplay(d *event.Discussion, cur *event.User) *DiscussionDispl
 
 	dd.IsFinal, dd.Time = d.Slot()
 
+	var err error
+	dd.Tags, err = event.DiscussionGetTags(d.DiscussionID)
+	if err != nil {
+		// Report error but continue
+		log.Printf("INTERNAL ERROR: Getting tags for discussion %v: %v", d.DiscussionID, err)
+	}
+
 	dd.Owner, _ = event.UserFind(d.Owner)
 	if cur != nil {
 		if cur.Username != event.AdminUsername {
@@ -113,15 +236,26 @@ func DiscussionGetDisplay(d *event.Discussion, cur *event.User) *DiscussionDispl
 			dd.Interest, _ = cur.GetInterest(d)
 		}
 		dd.MayEdit = cur.MayEditDiscussion(d)
+		if dd.MayEdit {
+			dd.PossibleSlots = event.TimetableGetPossibleSlots(d.DiscussionID)
+			dd.Orders = discussionOrders(d)
+			dd.OrderChoices = discussionOrderChoices(d, cur)
+		}
 		if cur.IsAdmin {
 			dd.IsAdmin = true
-			//dd.PossibleSlots = event.TimetableFillDisplaySlots(d.PossibleSlots)
-			var err error
 			dd.AllUsers, err = event.UserGetAll()
 			if err != nil {
 				// Report error but continue
 				log.Printf("INTERNAL ERROR: Getting all users: %v", err)
 			}
+			pin, err := event.DiscussionGetPin(d.DiscussionID)
+			if err != nil {
+				// Report error but continue
+				log.Printf("INTERNAL ERROR: Getting pin for discussion %v: %v", d.DiscussionID, err)
+			}
+			dd.IsPinned = pin != nil
+			dd.PinSlots = event.TimetableGetPinSlots(d.DiscussionID)
+			dd.PinLocations = discussionPinLocations(pin)
 		}
 	}
 	return dd
@@ -139,14 +273,21 @@ func DiscussionGetListUser(u *event.User, cur *event.User) (list []*DiscussionDi
 	return
 }
 
-func DiscussionGetList(cur *event.User) (list []*DiscussionDisplay) {
-	event.DiscussionIterate(func(d *event.Discussion) error {
+// DiscussionGetList returns all discussions cur may see, or only those
+// tagged with tag, if it's not empty.
+func DiscussionGetList(cur *event.User, tag string) (list []*DiscussionDisplay) {
+	f := func(d *event.Discussion) error {
 		dd := DiscussionGetDisplay(d, cur)
 		if dd != nil {
 			list = append(list, dd)
 		}
 		return nil
-	})
+	}
+	if tag != "" {
+		event.DiscussionIterateTag(tag, f)
+	} else {
+		event.DiscussionIterate(f)
+	}
 
 	return
 }
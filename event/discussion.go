@@ -6,6 +6,7 @@ import (
 	"log"
 
 	"github.com/gwd/session-scheduler/id"
+	"github.com/jmoiron/sqlx"
 )
 
 const (
@@ -51,21 +52,29 @@ type Discussion struct {
 	ApprovedTitle       string
 	ApprovedDescription string
 
-	// PossibleSlots []bool
-
 	// Is this discussion publicly visible?
 	// If true, 'Title' and 'Description' should be shown to everyone.
 	// If false:
 	//   admin and owner should see 'Title' and 'Description'
 	//   Everyone else should either see 'Approved*', or nothing at all (if nothing has been approved)
 	IsPublic bool
+
+	// Number of consecutive slots the discussion takes, from 1 to
+	// MaxDiscussionLength
+	Length int
 }
 
 // Annotated for display to an individual user
 
 type DisplaySlot struct {
 	Label   string
-	Index   int
+	SlotID  SlotID
+	Checked bool
+}
+
+type DisplayDay struct {
+	Label   string
+	DayID   DayID
 	Checked bool
 }
 
@@ -76,6 +85,8 @@ func (d *Discussion) GetURL() string {
 // FIXME
 const maxDiscussionsPerUser = 5
 
+const MaxDiscussionLength = 3
+
 func checkDiscussionParams(disc *Discussion) error {
 	if disc.Title == "" || AllWhitespace(disc.Title) {
 		log.Printf("%s New/Update discussion failed: no title",
@@ -88,6 +99,15 @@ func checkDiscussionParams(disc *Discussion) error {
 			disc.Owner)
 		return errNoDesc
 	}
+
+	if disc.Length == 0 {
+		disc.Length = 1
+	}
+	if disc.Length < 1 || disc.Length > MaxDiscussionLength {
+		log.Printf("%s New/Update discussion failed: invalid length %d",
+			disc.Owner, disc.Length)
+		return errInvalidLength
+	}
 	return nil
 }
 
@@ -153,10 +173,10 @@ func NewDiscussion(disc *Discussion) error {
 			disc.ApprovedDescription = ""
 		}
 		_, err = tx.Exec(
-			`insert into event_discussions values (?, ?, ?, ?, ?, ?, ?)`,
+			`insert into event_discussions values (?, ?, ?, ?, ?, ?, ?, ?)`,
 			disc.DiscussionID, disc.Owner, disc.Title, disc.Description,
 			disc.ApprovedTitle, disc.ApprovedDescription,
-			disc.IsPublic)
+			disc.IsPublic, disc.Length)
 		if shouldRetry(err) {
 			tx.Rollback()
 			continue
@@ -173,6 +193,14 @@ func NewDiscussion(disc *Discussion) error {
 			return err
 		}
 
+		err = schedInvalidateTx(tx)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Invalidating schedule: %v", err)
+		}
+
 		err = tx.Commit()
 		if shouldRetry(err) {
 			tx.Rollback()
@@ -211,14 +239,62 @@ func (d *Discussion) GetMaxScore() (int, error) {
 	}
 }
 
+// Location returns the location the discussion is scheduled in, or
+// an empty Location if it isn't scheduled.
 func (d *Discussion) Location() Location {
-	// FIXME: Location
-	return Location{}
+	var loc Location
+	for {
+		err := event.Get(&loc, `
+            select event_locations.*
+                from event_schedule
+                  natural join event_locations
+                where discussionid = ?`,
+			d.DiscussionID)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err == sql.ErrNoRows:
+			return Location{}
+		case err != nil:
+			log.Printf("INTERNAL ERROR: Getting location for discussion %v: %v",
+				d.DiscussionID, err)
+			return Location{}
+		default:
+			return loc
+		}
+	}
 }
 
+// Slot returns the time the discussion is scheduled for, and whether
+// that slot is final (i.e., locked); or "" if it isn't scheduled.
 func (d *Discussion) Slot() (IsFinal bool, Time string) {
-	// FIXME: Slot
-	return false, ""
+	var slot struct {
+		DayName  string
+		SlotTime DBTime
+		IsLocked bool
+	}
+	for {
+		err := event.Get(&slot, `
+            select dayname, slottime, islocked
+                from event_schedule
+                  natural join event_slots
+                  natural join event_days
+                where discussionid = ?
+                order by dayid, slotidx`,
+			d.DiscussionID)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err == sql.ErrNoRows:
+			return false, ""
+		case err != nil:
+			log.Printf("INTERNAL ERROR: Getting slot for discussion %v: %v",
+				d.DiscussionID, err)
+			return false, ""
+		default:
+			return slot.IsLocked, slot.DayName + " " + formatSlotTime(slot.SlotTime)
+		}
+	}
 }
 
 // Updates discussion's Title, Description, and Owner.
@@ -246,74 +322,59 @@ func DiscussionUpdate(disc *Discussion) error {
 		}
 		defer tx.Rollback()
 
-		var curOwner UserID
-		var ownerIsVerified bool
-		row := tx.QueryRow(
-			`select owner, isverified
-                 from event_discussions
-                   join event_users on owner = userid
-                 where discussionid = ?`, disc.DiscussionID)
-		err = row.Scan(&curOwner, &ownerIsVerified)
+		err = discussionUpdateTx(tx, disc)
 		if shouldRetry(err) {
 			tx.Rollback()
 			continue
 		} else if err != nil {
-			return fmt.Errorf("Getting info for owner of discussion %v: %v",
-				disc.DiscussionID, err)
+			return err
 		}
 
-		// NB: We don't check for number owned discussions. Only the
-		// admin can re-assign a discussion; they can be allowed the
-		// latitude.
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
 
-		if disc.Owner != curOwner {
-			err := setInterestTx(tx, disc.Owner, disc.DiscussionID, InterestMax)
-			if shouldRetry(err) {
-				tx.Rollback()
-				continue
-			} else if err != nil {
-				return fmt.Errorf("Setting interest for new owner %v: %v",
-					disc.Owner, err)
-			}
+		return nil
+	}
+}
 
-			// If we're changing owner, we need to see whether the new owner is verified
-			err = tx.Get(&ownerIsVerified,
-				`select isverified from event_users where userid = ?`,
-				disc.Owner)
-			if shouldRetry(err) {
-				tx.Rollback()
-				continue
-			} else if err != nil {
-				return fmt.Errorf("Getting IsVerified for new owner %v: %v",
-					disc.Owner, err)
-			}
-		}
+// DiscussionEdit updates disc as DiscussionUpdate does, and sets its
+// tags, and its possible slots unless possible is nil (see
+// DiscussionSetTags and DiscussionSetPossibleSlots), all in one
+// transaction, so that an edit is either made in full or not at all.
+func DiscussionEdit(disc *Discussion, tags []string, possible []SlotID) error {
+	log.Printf("Edit discussion post: '%s'", disc.Title)
 
-		// Editing a discussion takes it non-public unless the owner is verified.
-		disc.IsPublic = ownerIsVerified
+	if err := checkDiscussionParams(disc); err != nil {
+		return err
+	}
 
-		q :=
-			`update event_discussions set
-                 owner = ?,
-                 title = ?,
-                 description = ?,
-                 ispublic = ?`
-		args := []interface{}{disc.Owner, disc.Title, disc.Description, disc.IsPublic}
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return err
+	}
 
-		if disc.IsPublic {
-			disc.ApprovedTitle = disc.Title
-			disc.ApprovedDescription = disc.Description
-			q += `,
-                 approvedtitle = ?,
-                 approveddescription = ?`
-			args = append(args, disc.ApprovedTitle)
-			args = append(args, disc.ApprovedDescription)
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
 		}
+		defer tx.Rollback()
 
-		q += `where discussionid = ?`
-		args = append(args, disc.DiscussionID)
-
-		_, err = tx.Exec(q, args...)
+		err = discussionUpdateTx(tx, disc)
+		if err == nil && possible != nil {
+			err = possibleSlotsSetTx(tx, disc.DiscussionID, possible)
+		}
+		if err == nil {
+			err = discussionSetTagsTx(tx, disc.DiscussionID, tags)
+		}
 		if shouldRetry(err) {
 			tx.Rollback()
 			continue
@@ -333,6 +394,95 @@ func DiscussionUpdate(disc *Discussion) error {
 	}
 }
 
+func discussionUpdateTx(tx *sqlx.Tx, disc *Discussion) error {
+	var curOwner UserID
+	var ownerIsVerified bool
+	var curLength int
+	row := tx.QueryRow(
+		`select owner, isverified, length
+             from event_discussions
+               join event_users on owner = userid
+             where discussionid = ?`, disc.DiscussionID)
+	err := row.Scan(&curOwner, &ownerIsVerified, &curLength)
+	if err != nil {
+		return errOrRetry(fmt.Sprintf("Getting info for owner of discussion %v",
+			disc.DiscussionID), err)
+	}
+
+	// NB: We don't check for number owned discussions. Only the
+	// admin can re-assign a discussion; they can be allowed the
+	// latitude.
+
+	if disc.Owner != curOwner {
+		err := setInterestTx(tx, disc.Owner, disc.DiscussionID, InterestMax)
+		if err != nil {
+			return errOrRetry(fmt.Sprintf("Setting interest for new owner %v",
+				disc.Owner), err)
+		}
+
+		// If we're changing owner, we need to see whether the new owner is verified
+		err = tx.Get(&ownerIsVerified,
+			`select isverified from event_users where userid = ?`,
+			disc.Owner)
+		if err != nil {
+			return errOrRetry(fmt.Sprintf("Getting IsVerified for new owner %v",
+				disc.Owner), err)
+		}
+	}
+
+	// Editing a discussion takes it non-public unless the owner is verified.
+	disc.IsPublic = ownerIsVerified
+
+	q :=
+		`update event_discussions set
+             owner = ?,
+             title = ?,
+             description = ?,
+             ispublic = ?,
+             length = ?`
+	args := []interface{}{disc.Owner, disc.Title, disc.Description, disc.IsPublic,
+		disc.Length}
+
+	if disc.IsPublic {
+		disc.ApprovedTitle = disc.Title
+		disc.ApprovedDescription = disc.Description
+		q += `,
+             approvedtitle = ?,
+             approveddescription = ?`
+		args = append(args, disc.ApprovedTitle)
+		args = append(args, disc.ApprovedDescription)
+	}
+
+	q += `where discussionid = ?`
+	args = append(args, disc.DiscussionID)
+
+	_, err = tx.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+
+	// The slots the discussion is scheduled or pinned in no
+	// longer fit it
+	if disc.Length != curLength {
+		_, err = tx.Exec(`delete from event_schedule where discussionid = ?`,
+			disc.DiscussionID)
+		if err != nil {
+			return errOrRetry("Unscheduling discussion", err)
+		}
+
+		_, err = tx.Exec(`delete from event_discussion_pins where discussionid = ?`,
+			disc.DiscussionID)
+		if err != nil {
+			return errOrRetry("Unpinning discussion", err)
+		}
+	}
+
+	if err = schedInvalidateTx(tx); err != nil {
+		return errOrRetry("Invalidating schedule", err)
+	}
+	return nil
+}
+
 // Sets the given discussion ID to public or private.
 //
 // If public is true, it copies the title and description into the
@@ -384,6 +534,7 @@ func DiscussionSetPublic(discussionid DiscussionID, public bool) error {
 			log.Printf("ERROR Expected to change 1 row, changed %d", rcount)
 			return ErrInternal
 		}
+		schedInvalidate()
 		return nil
 
 	}
@@ -413,6 +564,56 @@ func DeleteDiscussion(did DiscussionID) error {
 			return fmt.Errorf("Deleting discussion from event_interest: %v", err)
 		}
 
+		_, err = tx.Exec(`
+           delete from event_schedule
+               where discussionid = ?`, did)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Deleting discussion from event_schedule: %v", err)
+		}
+
+		_, err = tx.Exec(`
+           delete from event_discussions_possible_slots
+               where discussionid = ?`, did)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Deleting discussion from event_discussions_possible_slots: %v", err)
+		}
+
+		_, err = tx.Exec(`
+           delete from event_discussion_tags
+               where discussionid = ?`, did)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Deleting discussion from event_discussion_tags: %v", err)
+		}
+
+		_, err = tx.Exec(`
+           delete from event_discussion_pins
+               where discussionid = ?`, did)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Deleting discussion from event_discussion_pins: %v", err)
+		}
+
+		_, err = tx.Exec(`
+           delete from event_discussion_orders
+               where beforeid = ? or afterid = ?`, did, did)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Deleting discussion from event_discussion_orders: %v", err)
+		}
+
 		res, err := tx.Exec(`
         delete from event_discussions
             where discussionid = ?`, did)
@@ -438,6 +639,14 @@ func DeleteDiscussion(did DiscussionID) error {
 			return ErrInternal
 		}
 
+		err = schedInvalidateTx(tx)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Invalidating schedule: %v", err)
+		}
+
 		err = tx.Commit()
 		if shouldRetry(err) {
 			tx.Rollback()
@@ -447,7 +656,103 @@ func DeleteDiscussion(did DiscussionID) error {
 		}
 		return nil
 	}
-	return nil
+}
+
+// DiscussionSetPossibleSlots restricts the slots discussion did may
+// be scheduled in to slots.  Listing every non-break slot removes
+// the restriction.  Listing none is a validation error.
+func DiscussionSetPossibleSlots(did DiscussionID, slots []SlotID) error {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		err = possibleSlotsSetTx(tx, did, slots)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+func possibleSlotsSetTx(tx *sqlx.Tx, did DiscussionID, slots []SlotID) error {
+	if len(slots) == 0 {
+		return errNoPossibleSlots
+	}
+
+	var count int
+	err := tx.Get(&count, `select count(*) from event_discussions where discussionid = ?`, did)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrDiscussionNotFound
+	}
+
+	_, err = tx.Exec(`
+        delete from event_discussions_possible_slots where discussionid = ?`, did)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[SlotID]bool)
+	for _, sid := range slots {
+		if seen[sid] {
+			continue
+		}
+		seen[sid] = true
+		res, err := tx.Exec(`
+            insert into event_discussions_possible_slots(discussionid, slotid)
+                select ?, slotid from event_slots
+                    where slotid = ? and isbreak = false`, did, sid)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrSlotNotFound
+		}
+	}
+
+	// If every slot is possible, there's no restriction to record
+	var unlisted int
+	err = tx.Get(&unlisted, `
+        select count(*) from event_slots
+            where isbreak = false
+              and slotid not in (select slotid from event_discussions_possible_slots
+                                     where discussionid = ?)`, did)
+	if err != nil {
+		return err
+	}
+	if unlisted == 0 {
+		_, err = tx.Exec(`
+            delete from event_discussions_possible_slots where discussionid = ?`, did)
+		if err != nil {
+			return err
+		}
+	}
+
+	return schedInvalidateTx(tx)
 }
 
 func MakePossibleSlots(len int) []bool {
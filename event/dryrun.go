@@ -0,0 +1,106 @@
+package event
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// A dry run searches for a schedule just as MakeSchedule does, but
+// returns it rather than storing it, along with how it differs from
+// the stored schedule, so that search options can be tried out on a
+// live event without disturbing it.
+
+type ScheduleDryRun struct {
+	Algo       SearchAlgo
+	Seed       int64
+	Iterations int
+	Score      int // Total utility, as for ScheduleVersion
+	Objective  string
+	MinPercent int // See UserReport.Percent
+	Timetable  Timetable
+	Violations []string
+	Warnings   []string
+	// Changes from the stored schedule; From and To are both 0
+	Diff ScheduleDiff
+}
+
+// MakeScheduleDryRun runs a search like MakeSchedule, and returns the
+// result without storing it.  optArg.Async is ignored.
+func MakeScheduleDryRun(optArg SearchOptions) (*ScheduleDryRun, error) {
+	optArg.Async = false
+	data, err := startSearch(optArg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		endRun()
+		schedClearRunning()
+	}()
+
+	best, err := search(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dr := &ScheduleDryRun{
+		Algo:       opt.Algo,
+		Seed:       opt.Seed,
+		Iterations: curRun.iterations(),
+		Score:      best.raw().utility(),
+		Objective:  opt.describeObjective(),
+		MinPercent: best.raw().minPercent(),
+	}
+
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Starting transaction: %v", err)
+		}
+		// Never committed
+		defer tx.Rollback()
+
+		err = dr.describeTx(tx, best)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		return dr, nil
+	}
+}
+
+// describeTx fills in the timetable, constraint violations and
+// changes for s, by storing it in tx, so that it's described in the
+// same way as the stored schedule.  tx must not be committed.
+func (dr *ScheduleDryRun) describeTx(tx *sqlx.Tx, s *schedule) error {
+	stored, err := storedEntriesTx(tx)
+	if err != nil {
+		return errOrRetry("Loading stored schedule", err)
+	}
+
+	if err = s.storeTx(tx); err != nil {
+		return err
+	}
+
+	proposed, err := storedEntriesTx(tx)
+	if err != nil {
+		return errOrRetry("Loading schedule", err)
+	}
+	dr.Diff = diffEntries(stored, proposed)
+
+	if dr.Timetable, err = getTimetableTx(tx); err != nil {
+		return errOrRetry("Getting timetable", err)
+	}
+
+	if dr.Violations, dr.Warnings, err = validateScheduleTx(tx, s); err != nil {
+		return errOrRetry("Validating schedule", err)
+	}
+
+	return nil
+}
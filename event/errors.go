@@ -24,12 +24,38 @@ var (
 	errNoDesc                   = ValidationError(errors.New("You must provide a description"))
 	errInvalidInterest          = ValidationError(errors.New("Interest value out of range"))
 	errTooManyDiscussions       = ValidationError(errors.New("You have too many discussions"))
+	errInvalidLength            = ValidationError(errors.New("Length out of range"))
 	errAllSlotsLocked           = ValidationError(errors.New("All slots are locked"))
 	errInProgress               = ValidationError(errors.New("Schedule already in progress"))
+	errNotInProgress            = ValidationError(errors.New("No schedule in progress"))
 	errModeratedDiscussions     = ValidationError(errors.New("Moderated discussions present: Please unmoderate or delete"))
+	errNoLocationName           = ValidationError(errors.New("You must provide a location name"))
+	errInvalidCapacity          = ValidationError(errors.New("Capacity out of range"))
+	errNoDayName                = ValidationError(errors.New("You must provide a day name"))
+	errNoSlots                  = ValidationError(errors.New("No slots to schedule discussions into"))
+	errNoLocations              = ValidationError(errors.New("No locations to schedule discussions into"))
+	errInvalidTag               = ValidationError(errors.New("Tags may only contain letters, numbers, and .+_-, and be at most 32 characters"))
+	errTooManyTags              = ValidationError(errors.New("Too many tags"))
+	errPinNotPlace              = ValidationError(errors.New("Discussions can only be pinned to locations which are places"))
+	errPinDoesntFit             = ValidationError(errors.New("The discussion doesn't fit there before the end of the day or the next break"))
+	errPinLocked                = ValidationError(errors.New("Discussions can't be pinned to locked slots"))
+	errPinConflict              = ValidationError(errors.New("Another discussion is pinned there, or by the same owner at the same time"))
+	errPinNotPossible           = ValidationError(errors.New("The discussion can't be scheduled in all of those slots"))
+	errPinUnavailable           = ValidationError(errors.New("The discussion's owner isn't available for all of those slots"))
+	errPinOrder                 = ValidationError(errors.New("That would put the discussion out of order with another pinned discussion"))
+	errLockPinned               = ValidationError(errors.New("A discussion is pinned to a slot being locked, but isn't scheduled there yet"))
+	errNoPossibleSlots          = ValidationError(errors.New("At least one slot must be possible"))
+	errOrderSelf                = ValidationError(errors.New("A discussion can't come before itself"))
+	errOrderCycle               = ValidationError(errors.New("That would make a discussion come before itself"))
 	ErrUserNotFound             = errors.New("UserID not found")
 	ErrDiscussionNotFound       = errors.New("DiscussionID not found")
 	ErrUserOrDiscussionNotFound = errors.New("UserID or DiscussionID not found")
+	ErrDayNotFound              = errors.New("DayID not found")
+	ErrSlotNotFound             = errors.New("SlotID not found")
+	ErrLocationNotFound         = errors.New("LocationID not found")
+	ErrVersionNotFound          = errors.New("Schedule version not found")
+	ErrSearchSpaceTooLarge      = errors.New("Search space too large for exact search")
+	ErrScheduleCancelled        = errors.New("Schedule cancelled")
 )
 
 func IsValidationError(err error) bool {
@@ -89,9 +89,7 @@ func (store *EventStore) Load(opt EventOptions) error {
 
 	handleAdminPwd(opt.AdminPwd)
 
-	// FIXME: Clean up any stale 'running' data
-
-	return nil
+	return schedRecover()
 }
 
 func Load(opt EventOptions) error {
@@ -26,9 +26,11 @@ CREATE TABLE event_discussions(
     approvedtitle       text,
     approveddescription text,
     ispublic            boolean not null,
+    length              integer not null default 1,
     foreign key(owner) references event_users(userid),
     unique(title));
 
+/* A discussion with no possible slots listed may go in any slot */
 CREATE TABLE event_discussions_possible_slots(
     discussionid text not null,
     slotid       text not null,
@@ -36,6 +38,45 @@ CREATE TABLE event_discussions_possible_slots(
     foreign key(slotid) references event_slots(slotid),
     unique(discussionid, slotid));
 
+/* Slots during which a user isn't at the event */
+CREATE TABLE event_users_unavailable_slots(
+    userid text not null,
+    slotid text not null,
+    foreign key(userid) references event_users(userid),
+    foreign key(slotid) references event_slots(slotid),
+    unique(userid, slotid));
+
+CREATE TABLE event_discussion_tags(
+    discussionid text not null,
+    tag          text not null,
+    foreign key(discussionid) references event_discussions(discussionid),
+    unique(discussionid, tag));
+
+/* Set by admins: the discussion must start in slotid, in locationid */
+CREATE TABLE event_discussion_pins(
+    discussionid text primary key,
+    slotid       text not null,
+    locationid   integer not null,
+    foreign key(discussionid) references event_discussions(discussionid),
+    foreign key(slotid) references event_slots(slotid),
+    foreign key(locationid) references event_locations(locationid));
+
+/* Set by owners or admins: beforeid must end before afterid starts,
+   on the same day if sameday is set */
+CREATE TABLE event_discussion_orders(
+    beforeid text not null,
+    afterid  text not null,
+    sameday  boolean not null,
+    foreign key(beforeid) references event_discussions(discussionid),
+    foreign key(afterid) references event_discussions(discussionid),
+    unique(beforeid, afterid));
+
+/* Scheduling rules for a tag; tags without a row avoid overlap only */
+CREATE TABLE event_tracks(
+    tag          text primary key,
+    avoidoverlap boolean not null,
+    sameroom     boolean not null);
+
 /* Location ids should be in order and contiguous, starting at 1 */
 CREATE TABLE event_locations(
     locationid   integer primary key,
@@ -66,6 +107,40 @@ CREATE TABLE event_schedule(
     locationid   integer not null,
     foreign key(discussionid) references event_discussions(discussionid),
     foreign key(slotid) references event_slots(slotid),
-    foreign key(locationid) references event_slots(locationid),
+    foreign key(locationid) references event_locations(locationid),
     unique(slotid, locationid));
 
+/* One row for every schedule stored, including rollbacks.  seed and
+ * iterations are those of the search which made the schedule (0 for
+ * rollbacks); score is its total utility when it was stored. */
+CREATE TABLE event_schedule_versions(
+    version    integer primary key,
+    created    string not null, /* Output of time.MarshalText() */
+    algo       text not null,
+    options    text not null,
+    seed       integer not null,
+    iterations integer not null,
+    score      integer not null,
+    objective  text not null,
+    minpercent integer not null);
+
+/* No foreign keys other than version, so that history is kept when
+ * discussions are deleted */
+CREATE TABLE event_schedule_version_entries(
+    version      integer not null,
+    discussionid text not null,
+    title        text not null,
+    slotid       text not null,
+    locationid   integer not null,
+    foreign key(version) references event_schedule_versions(version),
+    unique(version, discussionid));
+
+/* Single row.  generation is incremented whenever anything the
+ * scheduler uses changes; schedgeneration is the generation the
+ * current schedule was made from. */
+CREATE TABLE event_schedule_state(
+    running         boolean not null,
+    heartbeat       string not null, /* When the running search was last known to be alive */
+    generation      integer not null,
+    schedgeneration integer not null,
+    lastupdate      string not null); /* Output of time.MarshalText() */
@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestVersion(t *testing.T) {
@@ -95,6 +97,10 @@ func (tc testContext) cleanup() {
 }
 
 func TestEvent(t *testing.T) {
+	// Hashing passwords is slow on purpose; the tests make a lot
+	defer func(cost int) { hashCost = cost }(hashCost)
+	hashCost = bcrypt.MinCost
+
 	t.Logf("testUnitUser")
 	if testUnitUser(t) {
 		return
@@ -111,4 +117,96 @@ func TestEvent(t *testing.T) {
 	if testTransaction(t) {
 		return
 	}
+
+	if testUnitSchedule(t) {
+		return
+	}
+
+	if testUnitScheduleExact(t) {
+		return
+	}
+
+	if testUnitScheduleState(t) {
+		return
+	}
+
+	if testUnitScheduleOwnerConflicts(t) {
+		return
+	}
+
+	if testUnitSchedulePlacement(t) {
+		return
+	}
+
+	if testUnitScheduleLocked(t) {
+		return
+	}
+
+	if testUnitScheduleIncremental(t) {
+		return
+	}
+
+	if testUnitScheduleValidate(t) {
+		return
+	}
+
+	if testUnitScheduleReport(t) {
+		return
+	}
+
+	if testUnitScheduleVersions(t) {
+		return
+	}
+
+	if testUnitScheduleSeed(t) {
+		return
+	}
+
+	if testUnitScheduleWorkers(t) {
+		return
+	}
+
+	if testUnitSchedulePossible(t) {
+		return
+	}
+
+	if testUnitScheduleAvailability(t) {
+		return
+	}
+
+	if testUnitScheduleTracks(t) {
+		return
+	}
+
+	if testUnitScheduleObjective(t) {
+		return
+	}
+
+	if testUnitScheduleNormalize(t) {
+		return
+	}
+
+	if testUnitScheduleLength(t) {
+		return
+	}
+
+	if testUnitSchedulePin(t) {
+		return
+	}
+
+	if testUnitSchedulePinPlacement(t) {
+		return
+	}
+
+	if testUnitScheduleOrder(t) {
+		return
+	}
+
+	if testUnitScheduleDryRun(t) {
+		return
+	}
+
+	if testUnitTestPopulate(t) {
+		return
+	}
 }
@@ -9,7 +9,10 @@ import (
 	"github.com/mattn/go-sqlite3"
 )
 
-const codeSchemaVersion = 1
+// codeSchemaVersion must be incremented whenever the schema changes.
+// There are no migrations, so databases made with an older schema are
+// refused rather than failing later on a missing table or column.
+const codeSchemaVersion = 2
 
 func isSqliteErrorCode(err error, queries ...error) bool {
 	if err == nil {
@@ -126,6 +129,7 @@ CREATE TABLE event_discussions(
     approvedtitle       text,
     approveddescription text,
     ispublic            boolean not null,
+    length              integer not null default 1,
     foreign key(owner) references event_users(userid),
     unique(title))`)
 	if err != nil {
@@ -164,17 +168,83 @@ CREATE TABLE event_days(
 
 	_, err = ext.Exec(`
 CREATE TABLE event_slots(
-    slotid   integer primary key,
+    slotid   text primary key,
+    slotidx  integer not null, /* Order within a day */
     dayid    integer not null,
-    slottime string not null, /* Output of time.MarshalText() */
+    slottime string not null,  /* Output of time.MarshalText() */
     isbreak  boolean not null,
     islocked boolean not null,
     foreign  key(dayid) references event_days(dayid),
-    unique(dayid, slotid))`)
+    unique(dayid, slotidx))`)
 	if err != nil {
 		return errOrRetry("Creating table event_slots", err)
 	}
 
+	_, err = ext.Exec(`
+CREATE TABLE event_discussions_possible_slots(
+    discussionid text not null,
+    slotid       text not null,
+    foreign key(discussionid) references event_discussions(discussionid),
+    foreign key(slotid) references event_slots(slotid),
+    unique(discussionid, slotid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_discussions_possible_slots", err)
+	}
+
+	_, err = ext.Exec(`
+CREATE TABLE event_users_unavailable_slots(
+    userid text not null,
+    slotid text not null,
+    foreign key(userid) references event_users(userid),
+    foreign key(slotid) references event_slots(slotid),
+    unique(userid, slotid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_users_unavailable_slots", err)
+	}
+
+	_, err = ext.Exec(`
+CREATE TABLE event_discussion_tags(
+    discussionid text not null,
+    tag          text not null,
+    foreign key(discussionid) references event_discussions(discussionid),
+    unique(discussionid, tag))`)
+	if err != nil {
+		return errOrRetry("Creating table event_discussion_tags", err)
+	}
+
+	_, err = ext.Exec(`
+CREATE TABLE event_discussion_pins(
+    discussionid text primary key,
+    slotid       text not null,
+    locationid   integer not null,
+    foreign key(discussionid) references event_discussions(discussionid),
+    foreign key(slotid) references event_slots(slotid),
+    foreign key(locationid) references event_locations(locationid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_discussion_pins", err)
+	}
+
+	_, err = ext.Exec(`
+CREATE TABLE event_discussion_orders(
+    beforeid text not null,
+    afterid  text not null,
+    sameday  boolean not null,
+    foreign key(beforeid) references event_discussions(discussionid),
+    foreign key(afterid) references event_discussions(discussionid),
+    unique(beforeid, afterid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_discussion_orders", err)
+	}
+
+	_, err = ext.Exec(`
+CREATE TABLE event_tracks(
+    tag          text primary key,
+    avoidoverlap boolean not null,
+    sameroom     boolean not null)`)
+	if err != nil {
+		return errOrRetry("Creating table event_tracks", err)
+	}
+
 	_, err = ext.Exec(`
 CREATE TABLE event_schedule(
     discussionid text not null,
@@ -182,11 +252,55 @@ CREATE TABLE event_schedule(
     locationid   integer not null,
     foreign key(discussionid) references event_discussions(discussionid),
     foreign key(slotid) references event_slots(slotid),
-    foreign key(locationid) references event_slots(locationid),
+    foreign key(locationid) references event_locations(locationid),
     unique(slotid, locationid))`)
 	if err != nil {
 		return errOrRetry("Creating table event_schedule", err)
 	}
 
+	_, err = ext.Exec(`
+CREATE TABLE event_schedule_versions(
+    version    integer primary key,
+    created    string not null, /* Output of time.MarshalText() */
+    algo       text not null,
+    options    text not null,
+    seed       integer not null,
+    iterations integer not null,
+    score      integer not null,
+    objective  text not null,
+    minpercent integer not null)`)
+	if err != nil {
+		return errOrRetry("Creating table event_schedule_versions", err)
+	}
+
+	_, err = ext.Exec(`
+CREATE TABLE event_schedule_version_entries(
+    version      integer not null,
+    discussionid text not null,
+    title        text not null,
+    slotid       text not null,
+    locationid   integer not null,
+    foreign key(version) references event_schedule_versions(version),
+    unique(version, discussionid))`)
+	if err != nil {
+		return errOrRetry("Creating table event_schedule_version_entries", err)
+	}
+
+	_, err = ext.Exec(`
+CREATE TABLE event_schedule_state(
+    running         boolean not null,
+    heartbeat       string not null, /* When the running search was last known to be alive */
+    generation      integer not null,
+    schedgeneration integer not null,
+    lastupdate      string not null) /* Output of time.MarshalText() */`)
+	if err != nil {
+		return errOrRetry("Creating table event_schedule_state", err)
+	}
+
+	err = initSchedState(ext)
+	if err != nil {
+		return errOrRetry("Initializing event_schedule_state", err)
+	}
+
 	return nil
 }
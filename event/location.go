@@ -1,21 +1,86 @@
 package event
 
-// import "github.com/gwd/session-scheduler/id"
+import (
+	"database/sql"
+	"log"
+)
 
-type LocationID string
+type LocationID int
 
 type Location struct {
-	ID       LocationID
-	Name     string
-	IsPlace  bool
-	Capacity int
+	LocationID   LocationID
+	LocationName string
+	IsPlace      bool // Can discussions be scheduled here?
+	Capacity     int
 }
 
-const (
-	locationIDLength = 16
-)
+// Restrictions:
+// - Name can't be empty
+// - Capacity can't be negative
+//
+// Location IDs are assigned in order, starting at 1.
+func NewLocation(loc *Location) error {
+	if loc.LocationName == "" || AllWhitespace(loc.LocationName) {
+		log.Printf("New location failed: no name")
+		return errNoLocationName
+	}
+
+	if loc.Capacity < 0 {
+		log.Printf("New location failed: negative capacity %d", loc.Capacity)
+		return errInvalidCapacity
+	}
+
+	for {
+		res, err := event.Exec(`
+        insert into event_locations(locationname, isplace, capacity)
+            values(?, ?, ?)`,
+			loc.LocationName, loc.IsPlace, loc.Capacity)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err != nil:
+			log.Printf("New location failed: %v", err)
+			return err
+		}
+
+		lid, err := res.LastInsertId()
+		if err != nil {
+			log.Printf("ERROR Getting location id: %v", err)
+			return ErrInternal
+		}
+		loc.LocationID = LocationID(lid)
+		schedInvalidate()
+		return nil
+	}
+}
+
+// Return nil for location not present
+func LocationFindById(lid LocationID) (*Location, error) {
+	var loc Location
+	for {
+		err := event.Get(&loc, `select * from event_locations where locationid = ?`,
+			lid)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err == sql.ErrNoRows:
+			return nil, nil
+		case err != nil:
+			return nil, err
+		default:
+			return &loc, nil
+		}
+	}
+}
 
-func LocationGetAll() ([]Location, error) {
-	// TODO: Locations
-	return nil, nil
+func LocationGetAll() (locations []Location, err error) {
+	for {
+		err = event.Select(&locations, `select * from event_locations order by locationid`)
+		switch {
+		case shouldRetry(err):
+			continue
+		default:
+			return locations, err
+		}
+	}
 }
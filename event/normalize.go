@@ -0,0 +1,47 @@
+package event
+
+// Some people say they're very interested in everything; others use
+// the full range.  Left alone, the enthusiastic raters get more say
+// in the schedule than everyone else.  Normalizing scales each user's
+// interest so that it sums to InterestBudget, keeping its shape but
+// giving every user the same weight.
+
+const InterestBudget = 10 * InterestMax
+
+// normalized returns a copy of data with each user's interest scaled
+// to sum to InterestBudget.  Non-zero interest stays non-zero.
+func (data *searchData) normalized() *searchData {
+	n := *data
+	n.raw = data
+	n.discussions = make([]searchDiscussion, len(data.discussions))
+	n.userMax = make([]int, len(data.users))
+	for d := range data.discussions {
+		sd := data.discussions[d]
+		sd.interest = make([]userInterest, len(data.discussions[d].interest))
+		sd.maxScore = 0
+		for i, ui := range data.discussions[d].interest {
+			max := data.userMax[ui.user]
+			ui.interest = (ui.interest*InterestBudget + max/2) / max
+			if ui.interest == 0 {
+				ui.interest = 1
+			}
+			sd.interest[i] = ui
+			sd.maxScore += ui.interest
+			n.userMax[ui.user] += ui.interest
+		}
+		n.discussions[d] = sd
+	}
+	n.utilityBound = n.upperBound()
+	return &n
+}
+
+// raw returns s as a schedule of the data before normalization, for
+// reporting utility in terms of the interest people actually gave.
+func (s *schedule) raw() *schedule {
+	if s.data.raw == nil {
+		return s
+	}
+	r := s.clone()
+	r.data = s.data.raw
+	return r
+}
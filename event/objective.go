@@ -0,0 +1,96 @@
+package event
+
+import "fmt"
+
+// The objective is what the searches try to maximize, before the
+// churn and track penalties are taken off.  Total utility alone lets a
+// schedule favour a few people who are interested in a lot, at the
+// expense of everyone else; the other objectives take into account how
+// well off the worst-off user is, as a percentage of the interest
+// they've expressed (as in UserReport.Percent).
+
+type Objective string
+
+const (
+	// Total utility of all users
+	ObjectiveTotal = Objective("total")
+	// Percentage for the worst-off user, with total utility only
+	// breaking ties
+	ObjectiveMaxMin = Objective("maxmin")
+	// Total utility, plus FairnessWeight for each percentage point
+	// for the worst-off user
+	ObjectiveBlend = Objective("blend")
+)
+
+const FairnessDefaultWeight = InterestMax
+
+func (o Objective) valid() bool {
+	switch o {
+	case ObjectiveTotal, ObjectiveMaxMin, ObjectiveBlend:
+		return true
+	}
+	return false
+}
+
+// describeObjective summarizes the objective, for the schedule
+// version history.
+func (opt *SearchOptions) describeObjective() string {
+	if opt.Objective == ObjectiveBlend {
+		return fmt.Sprintf("%s (fairness weight %d)", opt.Objective, opt.FairnessWeight)
+	}
+	return string(opt.Objective)
+}
+
+// minPercent returns the lowest percentage of their maximum score
+// that any user with any interest gets, given each user's utility.
+func (data *searchData) minPercent(util []int) int {
+	min := 100
+	for u, max := range data.userMax {
+		if max == 0 {
+			continue
+		}
+		if p := util[u] * 100 / max; p < min {
+			min = p
+		}
+	}
+	return min
+}
+
+// minPercent returns the lowest percentage of their maximum score any
+// user gets from the schedule.
+func (s *schedule) minPercent() int {
+	return s.data.minPercent(s.userUtility())
+}
+
+// objective returns the value of the schedule under opt.Objective.
+func (s *schedule) objective() int {
+	util := s.userUtility()
+	total := 0
+	for _, u := range util {
+		total += u
+	}
+
+	switch opt.Objective {
+	case ObjectiveMaxMin:
+		// Any improvement for the worst-off user outweighs any
+		// change to the total
+		return s.data.minPercent(util)*(s.data.utilityBound+1) + total
+	case ObjectiveBlend:
+		return total + opt.FairnessWeight*s.data.minPercent(util)
+	default:
+		return total
+	}
+}
+
+// objectiveBound returns an upper bound on the objective of any
+// schedule whose total utility is at most utilityBound.
+func (data *searchData) objectiveBound(utilityBound int) int {
+	switch opt.Objective {
+	case ObjectiveMaxMin:
+		return 100*(data.utilityBound+1) + utilityBound
+	case ObjectiveBlend:
+		return utilityBound + opt.FairnessWeight*100
+	default:
+		return utilityBound
+	}
+}
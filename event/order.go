@@ -0,0 +1,163 @@
+package event
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Some discussions follow on from others: a "problem statement"
+// session, for instance, must happen before the "design follow-up".
+// Owners or admins can say that one discussion must end before another
+// starts, and optionally that they must be on the same day.  The
+// scheduler only puts discussions in the slots that keep them in
+// order; if one of them isn't scheduled, the other is free to go
+// anywhere which leaves room for it.
+
+type DiscussionOrder struct {
+	BeforeID DiscussionID
+	AfterID  DiscussionID
+	SameDay  bool
+}
+
+// DiscussionOrderAdd says that discussion before must end before
+// discussion after starts, on the same day if sameDay is true.  If the
+// two are already ordered that way, sameDay is updated.
+func DiscussionOrderAdd(before, after DiscussionID, sameDay bool) error {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		err = discussionOrderAddTx(tx, before, after, sameDay)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// discussionOrderAddTx checks that both discussions exist, and that
+// after doesn't already come before before, directly or through other
+// discussions.
+func discussionOrderAddTx(tx *sqlx.Tx, before, after DiscussionID, sameDay bool) error {
+	if before == after {
+		return errOrderSelf
+	}
+
+	for _, did := range []DiscussionID{before, after} {
+		var exists bool
+		err := tx.Get(&exists, `
+            select true from event_discussions where discussionid = ?`, did)
+		if err == sql.ErrNoRows {
+			return ErrDiscussionNotFound
+		} else if err != nil {
+			return err
+		}
+	}
+
+	var cycle bool
+	err := tx.Get(&cycle, `
+        with recursive following(discussionid) as (
+            select ?
+            union
+            select afterid
+                from event_discussion_orders
+                    join following on beforeid = following.discussionid)
+        select exists (select 1 from following where discussionid = ?)`,
+		after, before)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return errOrderCycle
+	}
+
+	_, err = tx.Exec(`
+        insert or replace into event_discussion_orders(beforeid, afterid, sameday)
+            values(?, ?, ?)`, before, after, sameDay)
+	if err != nil {
+		return err
+	}
+
+	return schedInvalidateTx(tx)
+}
+
+// DiscussionOrderRemove removes any ordering of discussion before
+// before discussion after.
+func DiscussionOrderRemove(before, after DiscussionID) error {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(`
+            delete from event_discussion_orders
+                where beforeid = ? and afterid = ?`, before, after)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = schedInvalidateTx(tx)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// DiscussionGetOrders returns the orderings discussion did is in,
+// either before or after another discussion.
+func DiscussionGetOrders(did DiscussionID) (orders []DiscussionOrder, err error) {
+	for {
+		err = event.Select(&orders, `
+            select beforeid, afterid, sameday
+                from event_discussion_orders
+                where beforeid = ? or afterid = ?
+                order by beforeid, afterid`, did, did)
+		switch {
+		case shouldRetry(err):
+			continue
+		default:
+			return orders, err
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package event
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Some discussions, such as keynotes or those announced elsewhere,
+// must be at a fixed time and place.  An admin can pin them to a slot
+// and location.  The scheduler treats pinned discussions like those in
+// locked slots: they stay where they're pinned, and everything else is
+// scheduled around them.
+
+type DiscussionPin struct {
+	DiscussionID DiscussionID
+	SlotID       SlotID
+	LocationID   LocationID
+}
+
+// DiscussionSetPin pins discussion did to start in slot, in location
+// loc, replacing any pin it already has.
+func DiscussionSetPin(did DiscussionID, slot SlotID, loc LocationID) error {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		err = discussionSetPinTx(tx, did, slot, loc)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// discussionSetPinTx checks that the discussion fits where it's being
+// pinned: every slot it would take is on the same day, with no break
+// and none locked, is one of its possible slots, and is one its owner
+// is available for; the location is a place; no other discussion is
+// pinned to the same location, or with the same owner, for any of
+// those slots; and it's in order with any other pinned discussion it
+// must come before or after.
+func discussionSetPinTx(tx *sqlx.Tx, did DiscussionID, slot SlotID, loc LocationID) error {
+	var disc Discussion
+	err := tx.Get(&disc, `select * from event_discussions where discussionid = ?`, did)
+	if err == sql.ErrNoRows {
+		return ErrDiscussionNotFound
+	} else if err != nil {
+		return err
+	}
+
+	var isPlace bool
+	err = tx.Get(&isPlace, `select isplace from event_locations where locationid = ?`, loc)
+	if err == sql.ErrNoRows {
+		return ErrLocationNotFound
+	} else if err != nil {
+		return err
+	}
+	if !isPlace {
+		return errPinNotPlace
+	}
+
+	length := disc.Length
+	var first Slot
+	err = tx.Get(&first, `select * from event_slots where slotid = ?`, slot)
+	if err == sql.ErrNoRows {
+		return ErrSlotNotFound
+	} else if err != nil {
+		return err
+	}
+
+	var span []Slot
+	err = tx.Select(&span, `
+        select * from event_slots
+            where dayid = ? and slotidx >= ? and slotidx < ?
+            order by slotidx`, first.DayID, first.SlotIdx, first.SlotIdx+length)
+	if err != nil {
+		return err
+	}
+	if len(span) != length {
+		return errPinDoesntFit
+	}
+	for _, s := range span {
+		if s.IsBreak {
+			return errPinDoesntFit
+		}
+		if s.IsLocked {
+			return errPinLocked
+		}
+	}
+
+	var possible []SlotID
+	err = tx.Select(&possible, `
+        select slotid from event_discussions_possible_slots
+            where discussionid = ?`, did)
+	if err != nil {
+		return err
+	}
+	var unavailable []SlotID
+	err = tx.Select(&unavailable, `
+        select slotid from event_users_unavailable_slots
+            where userid = ?`, disc.Owner)
+	if err != nil {
+		return err
+	}
+	for _, s := range span {
+		if len(possible) > 0 && !containsSlot(possible, s.SlotID) {
+			return errPinNotPossible
+		}
+		if containsSlot(unavailable, s.SlotID) {
+			return errPinUnavailable
+		}
+	}
+
+	var orders []struct {
+		IsBefore bool // Whether did must come before the other
+		SameDay  bool
+		DayID    DayID
+		SlotIdx  int
+		Length   int
+	}
+	err = tx.Select(&orders, `
+        select o.beforeid = ? as isbefore, o.sameday,
+               s.dayid, s.slotidx, d.length
+            from event_discussion_orders as o
+                join event_discussion_pins as p
+                    on p.discussionid = (case when o.beforeid = ? then o.afterid else o.beforeid end)
+                join event_discussions as d on d.discussionid = p.discussionid
+                join event_slots as s on s.slotid = p.slotid
+            where o.beforeid = ? or o.afterid = ?`,
+		did, did, did, did)
+	if err != nil {
+		return err
+	}
+	for _, o := range orders {
+		aDay, aIdx, aLength, bDay, bIdx := first.DayID, first.SlotIdx, length, o.DayID, o.SlotIdx
+		if !o.IsBefore {
+			aDay, aIdx, aLength, bDay, bIdx = o.DayID, o.SlotIdx, o.Length, first.DayID, first.SlotIdx
+		}
+		if aDay > bDay || (aDay == bDay && aIdx+aLength > bIdx) ||
+			(o.SameDay && aDay != bDay) {
+			return errPinOrder
+		}
+	}
+
+	var conflicts int
+	err = tx.Get(&conflicts, `
+        select count(*)
+            from event_discussion_pins as p
+                join event_discussions as d on d.discussionid = p.discussionid
+                join event_slots as s on s.slotid = p.slotid
+            where (p.locationid = ? or d.owner = ?) and p.discussionid != ?
+              and s.dayid = ?
+              and s.slotidx < ? and s.slotidx + d.length > ?`,
+		loc, disc.Owner, did, first.DayID, first.SlotIdx+length, first.SlotIdx)
+	if err != nil {
+		return err
+	}
+	if conflicts > 0 {
+		return errPinConflict
+	}
+
+	_, err = tx.Exec(`
+        insert or replace into event_discussion_pins(discussionid, slotid, locationid)
+            values(?, ?, ?)`, did, slot, loc)
+	if err != nil {
+		return err
+	}
+
+	return schedInvalidateTx(tx)
+}
+
+func containsSlot(slots []SlotID, sid SlotID) bool {
+	for _, s := range slots {
+		if s == sid {
+			return true
+		}
+	}
+	return false
+}
+
+// DiscussionClearPin unpins discussion did, if it's pinned.
+func DiscussionClearPin(did DiscussionID) error {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(`delete from event_discussion_pins where discussionid = ?`, did)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = schedInvalidateTx(tx)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// DiscussionGetPin returns where discussion did is pinned, or nil if
+// it isn't.
+func DiscussionGetPin(did DiscussionID) (*DiscussionPin, error) {
+	for {
+		var pin DiscussionPin
+		err := event.Get(&pin, `
+            select discussionid, slotid, locationid
+                from event_discussion_pins
+                where discussionid = ?`, did)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err == sql.ErrNoRows:
+			return nil, nil
+		case err != nil:
+			return nil, err
+		default:
+			return &pin, nil
+		}
+	}
+}
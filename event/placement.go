@@ -0,0 +1,174 @@
+package event
+
+import (
+	"log"
+	"sort"
+)
+
+// Placement is a separate step from scheduling: once the discussions
+// in each slot have been decided, each is given a location.  Within a
+// slot, the discussion with the largest expected audience goes in the
+// largest location, the next largest in the next largest, and so on.
+// The exception is tracks which should be kept in the same room: each
+// is given a room of its own, and its discussions go there if they
+// can.  Discussions which take several slots are placed in their first
+// slot, and keep the same location for the rest.
+
+// locationsBySize returns indexes into s.data.locations, largest
+// capacity first.  Ties go to the lower location ID.
+func (s *schedule) locationsBySize() []int {
+	locs := make([]int, len(s.data.locations))
+	for i := range locs {
+		locs[i] = i
+	}
+	sort.SliceStable(locs, func(i, j int) bool {
+		return s.data.locations[locs[i]].Capacity > s.data.locations[locs[j]].Capacity
+	})
+	return locs
+}
+
+// trackRooms returns the location for each track whose discussions
+// should share a room, or -1.  Tracks with the largest total expected
+// attendance get the largest locations; if there are more such tracks
+// than locations, the rest get none.
+func (s *schedule) trackRooms(attendees []int) []int {
+	rooms := make([]int, len(s.data.tracks))
+	total := make([]int, len(s.data.tracks))
+	var tracks []int
+	for t := range s.data.tracks {
+		rooms[t] = -1
+		if s.data.tracks[t].sameRoom {
+			tracks = append(tracks, t)
+		}
+	}
+	for d := range s.data.discussions {
+		if t := s.data.discussions[d].roomTrack; t >= 0 && s.slotOf[d] >= 0 {
+			total[t] += attendees[d]
+		}
+	}
+	sort.SliceStable(tracks, func(i, j int) bool {
+		return total[tracks[i]] > total[tracks[j]]
+	})
+
+	locs := s.locationsBySize()
+	for i, t := range tracks {
+		if i < len(locs) {
+			rooms[t] = locs[i]
+		}
+	}
+	return rooms
+}
+
+// placement returns the index of the location for each discussion,
+// or -1 for unscheduled discussions and any it can't find one for.
+// Discussions in locked slots keep the location they already had;
+// when rescheduling incrementally, so do discussions which haven't
+// changed slot, where possible.  Then
+// discussions go to their track's room, if it has one and it's free.
+// A discussion which takes several slots never gets a location which
+// something locked or pinned takes in a later one.  Discussions whose
+// expected attendance exceeds the capacity of their location are
+// logged.
+func (s *schedule) placement() []int {
+	locationOf := make([]int, len(s.slotOf))
+	for i := range locationOf {
+		locationOf[i] = -1
+	}
+
+	locs := s.locationsBySize()
+	attendees, _ := s.attendance()
+	rooms := s.trackRooms(attendees)
+	for slot, discs := range s.bySlot() {
+		used := make([]bool, len(s.data.locations))
+		// mayUse returns true if d, starting in this slot, may have
+		// location loc for all of its slots
+		mayUse := func(d, loc int) bool {
+			if used[loc] {
+				return false
+			}
+			for k := slot + 1; k < slot+s.data.discussions[d].length; k++ {
+				if r := s.data.reservedBy[k][loc]; r >= 0 && r != d {
+					return false
+				}
+			}
+			return true
+		}
+
+		var rest []int
+		for _, d := range discs {
+			if s.slotOf[d] != slot {
+				// Placed in an earlier slot
+				if locationOf[d] >= 0 {
+					used[locationOf[d]] = true
+				}
+			} else if loc := s.data.discussions[d].lockedLocation; loc >= 0 {
+				locationOf[d] = loc
+				used[loc] = true
+			} else {
+				rest = append(rest, d)
+			}
+		}
+
+		var free []int
+		for _, d := range rest {
+			sd := &s.data.discussions[d]
+			if loc := sd.baselineLocation; opt.Incremental &&
+				sd.baselineSlot == slot && loc >= 0 && mayUse(d, loc) {
+				locationOf[d] = loc
+				used[loc] = true
+			} else {
+				free = append(free, d)
+			}
+		}
+
+		sort.SliceStable(free, func(i, j int) bool {
+			return attendees[free[i]] > attendees[free[j]]
+		})
+		var others []int
+		for _, d := range free {
+			if t := s.data.discussions[d].roomTrack; t >= 0 &&
+				rooms[t] >= 0 && mayUse(d, rooms[t]) {
+				locationOf[d] = rooms[t]
+				used[rooms[t]] = true
+			} else {
+				others = append(others, d)
+			}
+		}
+
+		// Discussions which run into slots with reserved locations
+		// have less choice, so go first
+		blocked := make(map[int]int)
+		for _, d := range others {
+			for loc := range s.data.locations {
+				if !used[loc] && !mayUse(d, loc) {
+					blocked[d]++
+				}
+			}
+		}
+		sort.SliceStable(others, func(i, j int) bool {
+			return blocked[others[i]] > blocked[others[j]]
+		})
+		for _, d := range others {
+			for _, loc := range locs {
+				if mayUse(d, loc) {
+					locationOf[d] = loc
+					used[loc] = true
+					break
+				}
+			}
+		}
+
+		for _, d := range discs {
+			if locationOf[d] < 0 {
+				continue
+			}
+			loc := &s.data.locations[locationOf[d]]
+			if s.slotOf[d] == slot && attendees[d] > loc.Capacity {
+				log.Printf("WARNING: Discussion %v in slot %v expects %d attendees, but %s only holds %d",
+					s.data.discussions[d].id, s.data.slots[slot].id,
+					attendees[d], loc.LocationName, loc.Capacity)
+			}
+		}
+	}
+	return locationOf
+}
@@ -0,0 +1,262 @@
+package event
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// How good the stored schedule is for each user.  As when scheduling,
+// each user is assumed to go to the discussion they're most interested
+// in in each slot.
+
+type UserReportDiscussion struct {
+	DiscussionID DiscussionID
+	Title        string
+	Interest     int
+	// Interest after scaling, as if the search normalized interest
+	NormalizedInterest int
+	Time               string // "" if not scheduled
+	IsAttending        bool
+	// Takes several slots, and the user only attends some of them
+	IsPartial bool
+	// Scheduled in a slot during which the user isn't available
+	IsUnavailable bool
+	// If scheduled but not attending (all of it), the discussion the
+	// user goes to instead
+	ClashID    DiscussionID
+	ClashTitle string
+}
+
+type UserReport struct {
+	UserID      UserID
+	Discussions []UserReportDiscussion // Most interesting first
+	Utility     int                    // Sum of interest in discussions attended
+	MaxScore    int                    // Sum of interest in all discussions
+	// Utility and MaxScore, using normalized interest
+	NormalizedUtility  int
+	NormalizedMaxScore int
+}
+
+// Percent returns Utility as a percentage of MaxScore, or 100 if the
+// user isn't interested in anything.
+func (r *UserReport) Percent() int {
+	if r.MaxScore == 0 {
+		return 100
+	}
+	return r.Utility * 100 / r.MaxScore
+}
+
+type UtilityBucket struct {
+	Label string
+	Count int
+}
+
+// UtilityDistribution summarizes UserReport.Percent for all users with
+// any interest.
+type UtilityDistribution struct {
+	Users       int
+	MeanPercent int
+	MinPercent  int
+	Buckets     []UtilityBucket // 0-9%, 10-19%, ... 90-100%
+	// Totals of UserReport.Utility and MaxScore, raw and normalized
+	Utility            int
+	MaxScore           int
+	NormalizedUtility  int
+	NormalizedMaxScore int
+}
+
+// userReportsTx makes reports for the users in uids, or all users if
+// uids is nil.
+func userReportsTx(q sqlx.Queryer, uids []UserID) ([]UserReport, error) {
+	data, err := loadSearchDataTx(q)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := loadScheduleEntriesTx(q)
+	if err != nil {
+		return nil, err
+	}
+	var days []Day
+	if err = sqlx.Select(q, &days, `select * from event_days`); err != nil {
+		return nil, err
+	}
+	dayNames := make(map[DayID]string, len(days))
+	for _, day := range days {
+		dayNames[day.DayID] = day.DayName
+	}
+
+	s := data.scheduleFromEntries(entries)
+	ndata := data.normalized()
+
+	// For each user, the discussions they're interested in, and the
+	// one they go to in each slot
+	interest := make([][]userInterest, len(data.users))
+	normalized := make([]map[int]int, len(data.users))
+	choice := make([]map[int]userInterest, len(data.users))
+	for i := range choice {
+		normalized[i] = make(map[int]int)
+		choice[i] = make(map[int]userInterest)
+	}
+	for d := range data.discussions {
+		sd := &data.discussions[d]
+		start := s.slotOf[d]
+		for i, ui := range sd.interest {
+			interest[ui.user] = append(interest[ui.user],
+				userInterest{user: d, interest: ui.interest})
+			normalized[ui.user][d] = ndata.discussions[d].interest[i].interest
+			if start < 0 {
+				continue
+			}
+			for k := 0; k < sd.length; k++ {
+				slot := start + k
+				if !data.available(ui.user, slot) {
+					continue
+				}
+				share := sd.share(ui.interest, k)
+				if c, prs := choice[ui.user][slot]; !prs || share > c.interest {
+					choice[ui.user][slot] = userInterest{user: d, interest: share}
+				}
+			}
+		}
+	}
+
+	userIdx := make(map[UserID]int, len(data.users))
+	for i, uid := range data.users {
+		userIdx[uid] = i
+	}
+	if uids == nil {
+		uids = data.users
+	}
+
+	reports := make([]UserReport, len(uids))
+	for i, uid := range uids {
+		u, prs := userIdx[uid]
+		if !prs {
+			return nil, ErrUserNotFound
+		}
+		r := &reports[i]
+		r.UserID = uid
+		sort.SliceStable(interest[u], func(a, b int) bool {
+			return interest[u][a].interest > interest[u][b].interest
+		})
+		for _, ui := range interest[u] {
+			d := ui.user
+			rd := UserReportDiscussion{
+				DiscussionID:       data.discussions[d].id,
+				Title:              data.discussions[d].title,
+				Interest:           ui.interest,
+				NormalizedInterest: normalized[u][d],
+			}
+			r.MaxScore += ui.interest
+			r.NormalizedMaxScore += rd.NormalizedInterest
+			if start := s.slotOf[d]; start >= 0 {
+				sd := &data.discussions[d]
+				ss := &data.slots[start]
+				rd.Time = dayNames[ss.day] + " " + formatSlotTime(ss.time)
+				attended := 0
+				for k := 0; k < sd.length; k++ {
+					slot := start + k
+					if !data.available(u, slot) {
+						rd.IsUnavailable = true
+					} else if c := choice[u][slot]; c.user == d {
+						attended++
+						r.Utility += sd.share(ui.interest, k)
+						r.NormalizedUtility += sd.share(rd.NormalizedInterest, k)
+					} else if rd.ClashID == "" {
+						rd.ClashID = data.discussions[c.user].id
+						rd.ClashTitle = data.discussions[c.user].title
+					}
+				}
+				rd.IsAttending = attended > 0
+				rd.IsPartial = rd.IsAttending && attended < sd.length
+			}
+			r.Discussions = append(r.Discussions, rd)
+		}
+	}
+
+	return reports, nil
+}
+
+func userReports(uids []UserID) ([]UserReport, error) {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		reports, err := userReportsTx(tx, uids)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		return reports, nil
+	}
+}
+
+// UserReportGet returns the report for a single user.
+func UserReportGet(uid UserID) (*UserReport, error) {
+	reports, err := userReports([]UserID{uid})
+	if err != nil {
+		return nil, err
+	}
+	return &reports[0], nil
+}
+
+// UserReportGetAll returns reports for all users.
+func UserReportGetAll() ([]UserReport, error) {
+	return userReports(nil)
+}
+
+// UtilityDistributionGet summarizes how good the stored schedule is
+// across all users who are interested in anything.
+func UtilityDistributionGet() (*UtilityDistribution, error) {
+	reports, err := UserReportGetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	dist := &UtilityDistribution{MinPercent: 100}
+	dist.Buckets = make([]UtilityBucket, 10)
+	for i := range dist.Buckets {
+		dist.Buckets[i].Label = fmt.Sprintf("%d-%d%%", i*10, i*10+9)
+	}
+	dist.Buckets[9].Label = "90-100%"
+
+	total := 0
+	for i := range reports {
+		if reports[i].MaxScore == 0 {
+			continue
+		}
+		p := reports[i].Percent()
+		dist.Users++
+		dist.Utility += reports[i].Utility
+		dist.MaxScore += reports[i].MaxScore
+		dist.NormalizedUtility += reports[i].NormalizedUtility
+		dist.NormalizedMaxScore += reports[i].NormalizedMaxScore
+		total += p
+		if p < dist.MinPercent {
+			dist.MinPercent = p
+		}
+		b := p / 10
+		if b > 9 {
+			b = 9
+		}
+		dist.Buckets[b].Count++
+	}
+	if dist.Users > 0 {
+		dist.MeanPercent = total / dist.Users
+	} else {
+		dist.MinPercent = 0
+	}
+
+	return dist, nil
+}
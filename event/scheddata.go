@@ -0,0 +1,1003 @@
+package event
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// The scheduler works on an in-memory snapshot of the event, taken in
+// a single transaction, so that searches don't need to touch the
+// database and aren't confused by concurrent modification.  Users,
+// discussions, and slots are referred to by their index in the
+// snapshot.
+//
+// A discussion may take several consecutive slots on the same day, in
+// the same location.  It is scheduled by its first slot; it has a row
+// in event_schedule for each slot it takes.
+
+type userInterest struct {
+	user     int
+	interest int
+}
+
+type searchDiscussion struct {
+	id       DiscussionID
+	title    string
+	owner    int            // Index into users
+	ownerIdx int            // Index into the set of users who own discussions
+	interest []userInterest // Only users with non-zero interest
+	maxScore int
+	length   int // Number of consecutive slots taken
+	// If the discussion is in a locked slot, or pinned, the slot and
+	// location it must stay in; otherwise -1
+	lockedSlot     int
+	lockedLocation int
+	pinned         bool
+	// Where the discussion is in the stored schedule, or -1
+	baselineSlot     int
+	baselineLocation int
+	// Indexed by slot: whether the discussion may be scheduled
+	// there.  nil if it may go in any slot.
+	possible []bool
+	// Tracks whose discussions shouldn't overlap, as indexes into
+	// tracks
+	tracks []int
+	// The track the discussion should share a room with, or -1
+	roomTrack int
+	// The discussions this one must come after, and before
+	follows  []searchOrder
+	precedes []searchOrder
+}
+
+type searchOrder struct {
+	other   int // Index into discussions
+	sameDay bool
+}
+
+type searchTrack struct {
+	tag          string
+	avoidOverlap bool
+	sameRoom     bool
+}
+
+type searchSlot struct {
+	id     SlotID
+	day    DayID
+	idx    int
+	time   DBTime
+	locked bool
+}
+
+type searchData struct {
+	generation  int // See schedstate.go
+	users       []UserID
+	discussions []searchDiscussion
+	slots       []searchSlot // Non-break slots only, in order
+	locations   []Location   // Only locations which are places
+	ownerCount  int          // Number of distinct discussion owners
+	// Indexed by user, then slot: whether the user is away during
+	// the slot.  nil for users who are always available.
+	unavailable [][]bool
+	// Tracks with at least one rule, in tag order
+	tracks []searchTrack
+	// Indexed by slot, then location: the discussion in a locked slot,
+	// or pinned, which takes it, or -1
+	reservedBy [][]int
+	// Indexed by user: the sum of their interest in all discussions
+	userMax []int
+	// Upper bound on total utility; see upperBound
+	utilityBound int
+	// If interest has been normalized, the data before; otherwise nil
+	raw *searchData
+}
+
+func loadSearchDataTx(q sqlx.Queryer) (*searchData, error) {
+	data := &searchData{}
+
+	if err := sqlx.Get(q, &data.generation,
+		`select generation from event_schedule_state`); err != nil {
+		return nil, err
+	}
+
+	if err := sqlx.Select(q, &data.users,
+		`select userid from event_users order by userid`); err != nil {
+		return nil, err
+	}
+	userIdx := make(map[UserID]int, len(data.users))
+	for i, uid := range data.users {
+		userIdx[uid] = i
+	}
+
+	var discussions []Discussion
+	if err := sqlx.Select(q, &discussions, `
+        select * from event_discussions
+            where ispublic = true
+            order by discussionid`); err != nil {
+		return nil, err
+	}
+	discIdx := make(map[DiscussionID]int, len(discussions))
+	ownerIdx := make(map[UserID]int)
+	data.discussions = make([]searchDiscussion, len(discussions))
+	for i := range discussions {
+		sd := &data.discussions[i]
+		sd.id = discussions[i].DiscussionID
+		sd.title = discussions[i].Title
+		sd.owner = userIdx[discussions[i].Owner]
+		oidx, prs := ownerIdx[discussions[i].Owner]
+		if !prs {
+			oidx = len(ownerIdx)
+			ownerIdx[discussions[i].Owner] = oidx
+		}
+		sd.ownerIdx = oidx
+		sd.length = discussions[i].Length
+		if sd.length < 1 {
+			sd.length = 1
+		}
+		sd.lockedSlot = -1
+		sd.lockedLocation = -1
+		sd.baselineSlot = -1
+		sd.baselineLocation = -1
+		sd.roomTrack = -1
+		discIdx[sd.id] = i
+	}
+	data.ownerCount = len(ownerIdx)
+
+	var interest []struct {
+		UserID       UserID
+		DiscussionID DiscussionID
+		Interest     int
+	}
+	if err := sqlx.Select(q, &interest, `
+        select userid, discussionid, interest
+            from event_interest
+            order by discussionid, userid`); err != nil {
+		return nil, err
+	}
+	for _, i := range interest {
+		didx, prs := discIdx[i.DiscussionID]
+		if !prs || i.Interest == 0 {
+			continue
+		}
+		sd := &data.discussions[didx]
+		sd.interest = append(sd.interest,
+			userInterest{user: userIdx[i.UserID], interest: i.Interest})
+		sd.maxScore += i.Interest
+	}
+	data.userMax = make([]int, len(data.users))
+	for d := range data.discussions {
+		for _, ui := range data.discussions[d].interest {
+			data.userMax[ui.user] += ui.interest
+		}
+	}
+
+	var slots []Slot
+	if err := sqlx.Select(q, &slots,
+		`select * from event_slots order by dayid, slotidx`); err != nil {
+		return nil, err
+	}
+	for _, slot := range slots {
+		if slot.IsBreak {
+			continue
+		}
+		data.slots = append(data.slots, searchSlot{
+			id:     slot.SlotID,
+			day:    slot.DayID,
+			idx:    slot.SlotIdx,
+			time:   slot.SlotTime,
+			locked: slot.IsLocked})
+	}
+
+	if err := sqlx.Select(q, &data.locations, `
+        select * from event_locations
+            where isplace = true
+            order by locationid`); err != nil {
+		return nil, err
+	}
+
+	var possible []struct {
+		DiscussionID DiscussionID
+		SlotID       SlotID
+	}
+	if err := sqlx.Select(q, &possible, `
+        select discussionid, slotid
+            from event_discussions_possible_slots`); err != nil {
+		return nil, err
+	}
+	slotIdx := data.slotIndex()
+	for _, p := range possible {
+		d, dprs := discIdx[p.DiscussionID]
+		slot, sprs := slotIdx[p.SlotID]
+		if !dprs || !sprs {
+			continue
+		}
+		sd := &data.discussions[d]
+		if sd.possible == nil {
+			sd.possible = make([]bool, len(data.slots))
+		}
+		sd.possible[slot] = true
+	}
+
+	var unavailable []struct {
+		UserID UserID
+		SlotID SlotID
+	}
+	if err := sqlx.Select(q, &unavailable, `
+        select userid, slotid
+            from event_users_unavailable_slots`); err != nil {
+		return nil, err
+	}
+	data.unavailable = make([][]bool, len(data.users))
+	for _, u := range unavailable {
+		uidx, uprs := userIdx[u.UserID]
+		slot, sprs := slotIdx[u.SlotID]
+		if !uprs || !sprs {
+			continue
+		}
+		if data.unavailable[uidx] == nil {
+			data.unavailable[uidx] = make([]bool, len(data.slots))
+		}
+		data.unavailable[uidx][slot] = true
+	}
+
+	var tags []struct {
+		DiscussionID DiscussionID
+		Track
+	}
+	if err := sqlx.Select(q, &tags, `
+        select discussionid, tag,
+               coalesce(avoidoverlap, ?) as avoidoverlap,
+               coalesce(sameroom, ?) as sameroom
+            from event_discussion_tags natural left join event_tracks
+            order by tag, discussionid`,
+		defaultTrack.AvoidOverlap, defaultTrack.SameRoom); err != nil {
+		return nil, err
+	}
+	trackIdx := make(map[string]int)
+	for _, t := range tags {
+		d, prs := discIdx[t.DiscussionID]
+		if !prs || !(t.AvoidOverlap || t.SameRoom) {
+			continue
+		}
+		tidx, prs := trackIdx[t.Tag]
+		if !prs {
+			tidx = len(data.tracks)
+			trackIdx[t.Tag] = tidx
+			data.tracks = append(data.tracks, searchTrack{
+				tag:          t.Tag,
+				avoidOverlap: t.AvoidOverlap,
+				sameRoom:     t.SameRoom})
+		}
+		sd := &data.discussions[d]
+		if t.AvoidOverlap {
+			sd.tracks = append(sd.tracks, tidx)
+		}
+		if t.SameRoom && sd.roomTrack < 0 {
+			sd.roomTrack = tidx
+		}
+	}
+
+	var orders []DiscussionOrder
+	if err := sqlx.Select(q, &orders, `
+        select beforeid, afterid, sameday
+            from event_discussion_orders
+            order by beforeid, afterid`); err != nil {
+		return nil, err
+	}
+	for _, o := range orders {
+		before, bprs := discIdx[o.BeforeID]
+		after, aprs := discIdx[o.AfterID]
+		if !bprs || !aprs {
+			continue
+		}
+		data.discussions[before].precedes = append(data.discussions[before].precedes,
+			searchOrder{other: after, sameDay: o.SameDay})
+		data.discussions[after].follows = append(data.discussions[after].follows,
+			searchOrder{other: before, sameDay: o.SameDay})
+	}
+
+	// The stored schedule is the baseline for incremental
+	// rescheduling, and discussions already in locked slots stay
+	// where they are
+	entries, err := loadScheduleEntriesTx(q)
+	if err != nil {
+		return nil, err
+	}
+	locIdx := make(map[LocationID]int, len(data.locations))
+	for i := range data.locations {
+		locIdx[data.locations[i].LocationID] = i
+	}
+	// Entries for a discussion come in slot order; the first is the
+	// slot it's scheduled by.  It's locked if any of its slots is.
+	seen := make([]bool, len(data.discussions))
+	for _, e := range entries {
+		d, dprs := discIdx[e.DiscussionID]
+		slot, sprs := slotIdx[e.SlotID]
+		if !dprs || !sprs {
+			continue
+		}
+		sd := &data.discussions[d]
+		if !seen[d] {
+			seen[d] = true
+			if data.fits(d, slot) {
+				sd.baselineSlot = slot
+				if loc, prs := locIdx[e.LocationID]; prs {
+					sd.baselineLocation = loc
+				}
+			}
+		}
+		if sd.baselineSlot >= 0 && data.slots[slot].locked {
+			sd.lockedSlot = sd.baselineSlot
+			sd.lockedLocation = sd.baselineLocation
+		}
+	}
+
+	// Pinned discussions are fixed where they're pinned, whether or
+	// not they're there in the stored schedule
+	var pins []DiscussionPin
+	if err := sqlx.Select(q, &pins, `
+        select discussionid, slotid, locationid
+            from event_discussion_pins`); err != nil {
+		return nil, err
+	}
+	for _, p := range pins {
+		d, dprs := discIdx[p.DiscussionID]
+		slot, sprs := slotIdx[p.SlotID]
+		loc, lprs := locIdx[p.LocationID]
+		if !dprs || !sprs || !lprs || !data.fits(d, slot) {
+			continue
+		}
+		sd := &data.discussions[d]
+		sd.lockedSlot = slot
+		sd.lockedLocation = loc
+		sd.pinned = true
+	}
+
+	data.reservedBy = make([][]int, len(data.slots))
+	for slot := range data.reservedBy {
+		data.reservedBy[slot] = make([]int, len(data.locations))
+		for loc := range data.reservedBy[slot] {
+			data.reservedBy[slot][loc] = -1
+		}
+	}
+	for d := range data.discussions {
+		sd := &data.discussions[d]
+		if sd.lockedSlot < 0 || sd.lockedLocation < 0 {
+			continue
+		}
+		for k := sd.lockedSlot; k < sd.lockedSlot+sd.length; k++ {
+			data.reservedBy[k][sd.lockedLocation] = d
+		}
+	}
+
+	data.utilityBound = data.upperBound()
+
+	return data, nil
+}
+
+// loadSearchData takes a consistent snapshot of everything the
+// scheduler needs.
+func loadSearchData() (*searchData, error) {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		data, err := loadSearchDataTx(tx)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Loading search data: %v", err)
+		}
+
+		return data, nil
+	}
+}
+
+// A schedule assigns discussions to slots.  Which location each
+// discussion is in is decided separately, when the schedule is stored.
+type schedule struct {
+	data   *searchData
+	slotOf []int // Indexed by discussion; -1 if unscheduled
+	count  []int // Number of discussions in each slot
+	// Number of discussions by each owner in each slot, indexed by
+	// slot * data.ownerCount + ownerIdx
+	ownerBusy []int
+}
+
+// newSchedule returns a schedule with only the discussions which must
+// stay where they are: those in locked slots, and those pinned.
+func newSchedule(data *searchData) *schedule {
+	s := emptySchedule(data)
+	for d := range data.discussions {
+		if slot := data.discussions[d].lockedSlot; slot >= 0 {
+			s.assign(d, slot)
+		}
+	}
+	return s
+}
+
+func emptySchedule(data *searchData) *schedule {
+	s := &schedule{
+		data:      data,
+		slotOf:    make([]int, len(data.discussions)),
+		count:     make([]int, len(data.slots)),
+		ownerBusy: make([]int, len(data.slots)*data.ownerCount),
+	}
+	for i := range s.slotOf {
+		s.slotOf[i] = -1
+	}
+	return s
+}
+
+func (s *schedule) clone() *schedule {
+	n := &schedule{
+		data:      s.data,
+		slotOf:    make([]int, len(s.slotOf)),
+		count:     make([]int, len(s.count)),
+		ownerBusy: make([]int, len(s.ownerBusy)),
+	}
+	copy(n.slotOf, s.slotOf)
+	copy(n.count, s.count)
+	copy(n.ownerBusy, s.ownerBusy)
+	return n
+}
+
+func (s *schedule) ownerBusyIdx(d, slot int) int {
+	return slot*s.data.ownerCount + s.data.discussions[d].ownerIdx
+}
+
+// movable returns false if discussion d is in a locked slot, and so
+// must not be moved.
+func (s *schedule) movable(d int) bool {
+	return s.data.discussions[d].lockedSlot < 0
+}
+
+// available returns true if user u is at the event during slot.
+// Interest from users who aren't doesn't count towards utility.
+func (data *searchData) available(u, slot int) bool {
+	return data.unavailable[u] == nil || !data.unavailable[u][slot]
+}
+
+// mayUse returns true if the discussion may be scheduled in slot.
+func (sd *searchDiscussion) mayUse(slot int) bool {
+	return sd.possible == nil || sd.possible[slot]
+}
+
+// fits returns true if discussion d, starting in slot, would end on
+// the same day, with no break in between.  Since data.slots has no
+// breaks, its slots are slot, slot+1, and so on.
+func (data *searchData) fits(d, slot int) bool {
+	first := &data.slots[slot]
+	for k := 1; k < data.discussions[d].length; k++ {
+		if slot+k >= len(data.slots) ||
+			data.slots[slot+k].day != first.day ||
+			data.slots[slot+k].idx != first.idx+k {
+			return false
+		}
+	}
+	return true
+}
+
+// inSlot returns true if discussion d is scheduled in slot, either
+// starting there or continuing from an earlier slot.
+func (s *schedule) inSlot(d, slot int) bool {
+	start := s.slotOf[d]
+	return start >= 0 && slot >= start && slot < start+s.data.discussions[d].length
+}
+
+// share returns how much of interest in discussion sd counts in the
+// k'th slot it takes.  A discussion which takes several slots is worth
+// its interest once, split between them, so that going to it costs
+// users as many slots as it takes.
+func (sd *searchDiscussion) share(interest, k int) int {
+	share := interest / sd.length
+	if k < interest%sd.length {
+		share++
+	}
+	return share
+}
+
+// mayStart returns true if discussion d may start in slot, whatever
+// else is scheduled: it fits, none of the slots it would take are
+// locked or outside its possible slots, and some location isn't taken
+// in any of them by something locked or pinned.
+func (data *searchData) mayStart(d, slot int) bool {
+	if !data.fits(d, slot) {
+		return false
+	}
+	sd := &data.discussions[d]
+	for k := slot; k < slot+sd.length; k++ {
+		if data.slots[k].locked || !sd.mayUse(k) {
+			return false
+		}
+	}
+	return data.roomFree(d, slot)
+}
+
+// roomFree returns true if some location isn't reserved for another
+// discussion in any of the slots d would take starting in slot, so
+// that d can stay in one location throughout.
+func (data *searchData) roomFree(d, slot int) bool {
+	for loc := range data.locations {
+		free := true
+		for k := slot; k < slot+data.discussions[d].length; k++ {
+			if r := data.reservedBy[k][loc]; r >= 0 && r != d {
+				free = false
+				break
+			}
+		}
+		if free {
+			return true
+		}
+	}
+	return false
+}
+
+// inOrder returns true if discussion a, starting in aSlot, ends
+// before discussion b, starting in bSlot, starts; and if sameDay, that
+// they're on the same day.
+func (data *searchData) inOrder(a, aSlot, b, bSlot int, sameDay bool) bool {
+	if aSlot+data.discussions[a].length > bSlot {
+		return false
+	}
+	return !sameDay || data.slots[aSlot].day == data.slots[bSlot].day
+}
+
+// mayOrder returns true if discussion a, starting in aSlot, and
+// discussion b, starting in bSlot, are in order.  A slot of -1 means
+// that discussion isn't scheduled; the other must then leave it room
+// to start somewhere in order, unless it can't start anywhere.
+func (data *searchData) mayOrder(a, aSlot, b, bSlot int, sameDay bool) bool {
+	if aSlot >= 0 && bSlot >= 0 {
+		return data.inOrder(a, aSlot, b, bSlot, sameDay)
+	}
+	if aSlot < 0 && bSlot < 0 {
+		return true
+	}
+	anywhere := false
+	for k := range data.slots {
+		if (aSlot < 0 && !data.mayStart(a, k)) || (bSlot < 0 && !data.mayStart(b, k)) {
+			continue
+		}
+		anywhere = true
+		if (aSlot < 0 && data.inOrder(a, k, b, bSlot, sameDay)) ||
+			(bSlot < 0 && data.inOrder(a, aSlot, b, k, sameDay)) {
+			return true
+		}
+	}
+	return !anywhere
+}
+
+// ordered returns true if discussion d, starting in slot, would be in
+// order with the discussions it must come after or before.
+func (s *schedule) ordered(d, slot int) bool {
+	sd := &s.data.discussions[d]
+	for _, o := range sd.follows {
+		if !s.data.mayOrder(o.other, s.slotOf[o.other], d, slot, o.sameDay) {
+			return false
+		}
+	}
+	for _, o := range sd.precedes {
+		if !s.data.mayOrder(d, slot, o.other, s.slotOf[o.other], o.sameDay) {
+			return false
+		}
+	}
+	return true
+}
+
+// canAssign returns true if discussion d may be put into slot.  d
+// must not currently be scheduled.  The hard constraints are, for
+// every slot d would take:
+// - The slot must be on the same day as slot, with no break between
+// - The slot must not be locked
+// - The slot must be one of d's possible slots
+// - The owner of d must be available during the slot
+// - There must be a free location
+// - The owner of d must not have another discussion in the slot
+//
+// And d must be in order with the discussions it must come after or
+// before (see ordered).
+func (s *schedule) canAssign(d, slot int) bool {
+	if !s.data.mayStart(d, slot) {
+		return false
+	}
+	sd := &s.data.discussions[d]
+	for k := slot; k < slot+sd.length; k++ {
+		if !s.data.available(sd.owner, k) ||
+			s.count[k] >= len(s.data.locations) ||
+			s.ownerBusy[s.ownerBusyIdx(d, k)] != 0 {
+			return false
+		}
+	}
+	return s.ordered(d, slot)
+}
+
+func (s *schedule) assign(d, slot int) {
+	s.slotOf[d] = slot
+	for k := slot; k < slot+s.data.discussions[d].length; k++ {
+		s.count[k]++
+		s.ownerBusy[s.ownerBusyIdx(d, k)]++
+	}
+}
+
+func (s *schedule) unassign(d int) {
+	if slot := s.slotOf[d]; slot >= 0 {
+		for k := slot; k < slot+s.data.discussions[d].length; k++ {
+			s.count[k]--
+			s.ownerBusy[s.ownerBusyIdx(d, k)]--
+		}
+		s.slotOf[d] = -1
+	}
+}
+
+// bySlot returns the discussions in each slot, in discussion order.
+// Discussions which take several slots are in each of them.
+func (s *schedule) bySlot() [][]int {
+	slots := make([][]int, len(s.data.slots))
+	for d, slot := range s.slotOf {
+		if slot < 0 {
+			continue
+		}
+		for k := slot; k < slot+s.data.discussions[d].length; k++ {
+			slots[k] = append(slots[k], d)
+		}
+	}
+	return slots
+}
+
+// userUtility returns the utility each user gets from the schedule,
+// assuming that in every slot they go to the discussion they're most
+// interested in, if they're available.  See share for discussions
+// which take several slots.
+func (s *schedule) userUtility() []int {
+	util := make([]int, len(s.data.users))
+	best := make([]int, len(s.data.users))
+	for slot, discs := range s.bySlot() {
+		var touched []int
+		for _, d := range discs {
+			sd := &s.data.discussions[d]
+			for _, ui := range sd.interest {
+				if !s.data.available(ui.user, slot) {
+					continue
+				}
+				if best[ui.user] == 0 {
+					touched = append(touched, ui.user)
+				}
+				if i := sd.share(ui.interest, slot-s.slotOf[d]); i > best[ui.user] {
+					best[ui.user] = i
+				}
+			}
+		}
+		for _, u := range touched {
+			util[u] += best[u]
+			best[u] = 0
+		}
+	}
+	return util
+}
+
+// utility returns the total utility of the schedule for all users.
+func (s *schedule) utility() int {
+	utility := 0
+	for _, u := range s.userUtility() {
+		utility += u
+	}
+	return utility
+}
+
+// moved returns the number of discussions which are not where they
+// are in the stored schedule, including those which are no longer
+// scheduled at all.
+func (s *schedule) moved() int {
+	moved := 0
+	for d, slot := range s.slotOf {
+		if b := s.data.discussions[d].baselineSlot; b >= 0 && slot != b {
+			moved++
+		}
+	}
+	return moved
+}
+
+// churn returns the penalty for moving discussions away from where
+// they are in the stored schedule when rescheduling incrementally:
+// opt.ChurnWeight for each person (interested users and the owner)
+// who needs to find out that a discussion has moved.
+func (s *schedule) churn() int {
+	if !opt.Incremental {
+		return 0
+	}
+	churn := 0
+	for d, slot := range s.slotOf {
+		if b := s.data.discussions[d].baselineSlot; b >= 0 && slot != b {
+			churn += opt.ChurnWeight * (len(s.data.discussions[d].interest) + 1)
+		}
+	}
+	return churn
+}
+
+// sharedTracks returns the number of tracks discussions d and od are
+// both in, whose discussions shouldn't overlap.
+func (data *searchData) sharedTracks(d, od int) int {
+	shared := 0
+	for _, t := range data.discussions[d].tracks {
+		for _, ot := range data.discussions[od].tracks {
+			if t == ot {
+				shared++
+			}
+		}
+	}
+	return shared
+}
+
+// overlaps returns the number of pairs of discussions in the same
+// slot which are in the same track, counting each track they share.
+func (s *schedule) overlaps() int {
+	if len(s.data.tracks) == 0 {
+		return 0
+	}
+	overlaps := 0
+	for _, discs := range s.bySlot() {
+		for i, d := range discs {
+			for _, od := range discs[i+1:] {
+				overlaps += s.data.sharedTracks(d, od)
+			}
+		}
+	}
+	return overlaps
+}
+
+// trackPenalty returns the penalty for putting discussions from the
+// same track in the same slot: opt.TrackWeight for each overlap.
+func (s *schedule) trackPenalty() int {
+	return opt.TrackWeight * s.overlaps()
+}
+
+// score is what the searches maximize: the objective (see
+// objective.go), less the churn and track penalties.
+func (s *schedule) score() int {
+	return s.objective() - s.churn() - s.trackPenalty()
+}
+
+// attendance returns the number of attendees for each discussion,
+// and the sum of their interest.  For discussions which take several
+// slots, attendees is the most in any one of them.
+func (s *schedule) attendance() (attendees []int, score []int) {
+	attendees = make([]int, len(s.data.discussions))
+	score = make([]int, len(s.data.discussions))
+	for slot, discs := range s.bySlot() {
+		choice := make(map[int]userInterest)
+		for _, d := range discs {
+			sd := &s.data.discussions[d]
+			for _, ui := range sd.interest {
+				if !s.data.available(ui.user, slot) {
+					continue
+				}
+				i := sd.share(ui.interest, slot-s.slotOf[d])
+				if c, prs := choice[ui.user]; !prs || i > c.interest {
+					choice[ui.user] = userInterest{user: d, interest: i}
+				}
+			}
+		}
+		count := make(map[int]int)
+		for _, c := range choice {
+			count[c.user]++
+			score[c.user] += c.interest
+		}
+		for d, n := range count {
+			if n > attendees[d] {
+				attendees[d] = n
+			}
+		}
+	}
+	return
+}
+
+func (s *schedule) storeTx(ext sqlx.Ext) error {
+	_, err := ext.Exec(`delete from event_schedule`)
+	if err != nil {
+		return err
+	}
+
+	locationOf := s.placement()
+	for d, slot := range s.slotOf {
+		if slot < 0 {
+			continue
+		}
+		if locationOf[d] < 0 {
+			return fmt.Errorf("No location for discussion %v in slot %v",
+				s.data.discussions[d].id, s.data.slots[slot].id)
+		}
+		for k := slot; k < slot+s.data.discussions[d].length; k++ {
+			_, err = ext.Exec(`
+                insert into event_schedule(discussionid, slotid, locationid)
+                    values(?, ?, ?)`,
+				s.data.discussions[d].id,
+				s.data.slots[k].id,
+				s.data.locations[locationOf[d]].LocationID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// store replaces the contents of event_schedule with this schedule,
+// records it as a new version, and marks the search as complete.
+func (s *schedule) store() error {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		err = s.storeTx(tx)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Storing schedule: %v", err)
+		}
+
+		if opt.Validate {
+			violations, warnings, err := validateScheduleTx(tx, s)
+			if shouldRetry(err) {
+				tx.Rollback()
+				continue
+			} else if err != nil {
+				return fmt.Errorf("Validating stored schedule: %v", err)
+			}
+			for _, w := range warnings {
+				log.Printf("Schedule warning: %s", w)
+			}
+			if len(violations) > 0 {
+				return fmt.Errorf("Stored schedule has %d constraint violations: %s",
+					len(violations), strings.Join(violations, "; "))
+			}
+		}
+
+		_, err = recordVersionTx(tx, &ScheduleVersion{
+			Algo:       string(opt.Algo),
+			Options:    opt.describe(),
+			Seed:       opt.Seed,
+			Iterations: curRun.iterations(),
+			Score:      s.raw().utility(),
+			Objective:  opt.describeObjective(),
+			MinPercent: s.raw().minPercent(),
+		})
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Recording schedule version: %v", err)
+		}
+
+		err = schedCompleteTx(tx, s.data.generation)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Updating schedule state: %v", err)
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+type scheduleEntry struct {
+	DiscussionID DiscussionID
+	SlotID       SlotID
+	LocationID   LocationID
+}
+
+func loadScheduleEntriesTx(q sqlx.Queryer) (entries []scheduleEntry, err error) {
+	err = sqlx.Select(q, &entries, `
+        select discussionid, slotid, locationid
+            from event_schedule natural join event_slots
+            order by discussionid, dayid, slotidx`)
+	return
+}
+
+// loadSchedule takes a snapshot of the search data together with the
+// currently stored schedule.  Discussions or slots in the stored
+// schedule which are no longer schedulable are ignored.
+func loadSchedule() (*schedule, []scheduleEntry, error) {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		data, err := loadSearchDataTx(tx)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("Loading search data: %v", err)
+		}
+
+		entries, err := loadScheduleEntriesTx(tx)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("Loading schedule: %v", err)
+		}
+
+		return data.scheduleFromEntries(entries), entries, nil
+	}
+}
+
+func (data *searchData) allSlotsLocked() bool {
+	for i := range data.slots {
+		if !data.slots[i].locked {
+			return false
+		}
+	}
+	return true
+}
+
+func (data *searchData) discussionIndex() map[DiscussionID]int {
+	idx := make(map[DiscussionID]int, len(data.discussions))
+	for i := range data.discussions {
+		idx[data.discussions[i].id] = i
+	}
+	return idx
+}
+
+func (data *searchData) slotIndex() map[SlotID]int {
+	idx := make(map[SlotID]int, len(data.slots))
+	for i := range data.slots {
+		idx[data.slots[i].id] = i
+	}
+	return idx
+}
+
+// scheduleFromEntries returns the schedule stored in entries, and
+// nothing else: pinned discussions are only where they're stored, if
+// anywhere.
+func (data *searchData) scheduleFromEntries(entries []scheduleEntry) *schedule {
+	s := emptySchedule(data)
+	discIdx := data.discussionIndex()
+	slotIdx := data.slotIndex()
+	seen := make([]bool, len(data.discussions))
+	for _, e := range entries {
+		d, dprs := discIdx[e.DiscussionID]
+		slot, sprs := slotIdx[e.SlotID]
+		if !dprs || !sprs || seen[d] {
+			continue
+		}
+		// Only the first entry for each discussion is where it starts
+		seen[d] = true
+		if s.slotOf[d] >= 0 || !data.fits(d, slot) {
+			continue
+		}
+		s.assign(d, slot)
+	}
+	return s
+}
@@ -0,0 +1,182 @@
+package event
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// The schedule state is kept in the single row of
+// event_schedule_state:
+//
+// - running is true while a search is in progress, so that only one
+//   search runs at a time (even across processes), and so that a run
+//   interrupted by a crash can be detected and cleaned up on load.
+//   heartbeat is refreshed while the search runs, so that only a
+//   search which has stopped doing so is cleaned up.
+//
+// - generation is incremented whenever anything the scheduler uses
+//   changes; schedgeneration is the generation the current schedule
+//   was made from.  If they differ, the schedule is stale.
+
+// A running search refreshes its heartbeat every schedHeartbeat; one
+// whose heartbeat is older than schedLease has been abandoned.
+const (
+	schedLease     = time.Minute
+	schedHeartbeat = schedLease / 4
+)
+
+type schedStateRow struct {
+	Running         bool
+	Heartbeat       DBTime
+	Generation      int
+	SchedGeneration int
+	LastUpdate      DBTime
+}
+
+func initSchedState(ext sqlx.Ext) error {
+	_, err := ext.Exec(`
+        insert into event_schedule_state(running, heartbeat, generation, schedgeneration, lastupdate)
+            values(false, ?, 0, 0, ?)`, DBTime{}, DBTime{})
+	return err
+}
+
+func getSchedState() (schedStateRow, error) {
+	var state schedStateRow
+	for {
+		err := event.Get(&state, `select * from event_schedule_state`)
+		switch {
+		case shouldRetry(err):
+			continue
+		default:
+			return state, err
+		}
+	}
+}
+
+// schedInvalidateTx records that something the scheduler uses has
+// changed, making the current schedule stale.
+func schedInvalidateTx(ext sqlx.Execer) error {
+	_, err := ext.Exec(`update event_schedule_state set generation = generation + 1`)
+	return err
+}
+
+// schedInvalidate is schedInvalidateTx for callers not already in a
+// transaction.  Failure is logged but not returned, as the change
+// itself has already been made.
+func schedInvalidate() {
+	for {
+		err := schedInvalidateTx(event)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err != nil:
+			log.Printf("INTERNAL ERROR: Invalidating schedule: %v", err)
+		}
+		return
+	}
+}
+
+// schedSetRunning atomically claims the right to run a search.  It
+// returns errInProgress if a search is already running.
+func schedSetRunning() error {
+	for {
+		res, err := event.Exec(`
+        update event_schedule_state set running = true, heartbeat = ? where running = false`,
+			DBTime{time.Now()})
+		switch {
+		case shouldRetry(err):
+			continue
+		case err != nil:
+			return fmt.Errorf("Setting schedule running: %v", err)
+		}
+
+		rcount, err := res.RowsAffected()
+		if err != nil {
+			log.Printf("ERROR Getting number of affected rows: %v", err)
+			return ErrInternal
+		}
+		if rcount == 0 {
+			return errInProgress
+		}
+		return nil
+	}
+}
+
+// schedBeat refreshes the heartbeat of the running search.  Failure
+// is logged; the next beat may succeed.
+func schedBeat() {
+	for {
+		_, err := event.Exec(`
+            update event_schedule_state set heartbeat = ? where running = true`,
+			DBTime{time.Now()})
+		switch {
+		case shouldRetry(err):
+			continue
+		case err != nil:
+			log.Printf("ERROR: Refreshing schedule heartbeat: %v", err)
+		}
+		return
+	}
+}
+
+func schedClearRunningTx(ext sqlx.Execer) error {
+	_, err := ext.Exec(`update event_schedule_state set running = false`)
+	return err
+}
+
+func schedClearRunning() {
+	for {
+		err := schedClearRunningTx(event)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err != nil:
+			log.Printf("INTERNAL ERROR: Clearing schedule running state: %v", err)
+		}
+		return
+	}
+}
+
+// schedCompleteTx records that a search has finished, and that the
+// schedule now reflects generation.
+func schedCompleteTx(ext sqlx.Execer, generation int) error {
+	_, err := ext.Exec(`
+        update event_schedule_state
+            set running = false, schedgeneration = ?, lastupdate = ?`,
+		generation, DBTime{time.Now()})
+	return err
+}
+
+// schedRecover cleans up after a search which was interrupted (for
+// instance, by a crash): the partially-run search is discarded, and
+// the existing schedule left as it was.  A search still running, in
+// this process or another such as the server, keeps its heartbeat
+// fresh, and is left alone.
+func schedRecover() error {
+	state, err := getSchedState()
+	if err != nil {
+		return fmt.Errorf("Getting schedule state: %v", err)
+	}
+	if !state.Running || time.Since(state.Heartbeat.Time) < schedLease {
+		return nil
+	}
+
+	log.Printf("Cleaning up stale running schedule state (last heartbeat %v)",
+		state.Heartbeat.Time)
+	for {
+		// Unless another search has started since
+		_, err := event.Exec(`
+            update event_schedule_state set running = false
+                where running = true and heartbeat = ?`, state.Heartbeat)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err != nil:
+			return fmt.Errorf("Clearing schedule running state: %v", err)
+		}
+		return nil
+	}
+}
@@ -1,18 +1,24 @@
 package event
 
 import (
-	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"strings"
+	"sync"
 	"time"
-	//"github.com/hako/durafmt"
+
+	"github.com/hako/durafmt"
 )
 
 type SearchAlgo string
 
 const (
 	SearchHeuristicOnly = SearchAlgo("heuristic")
-	//SearchGenetic       = SearchAlgo("genetic")
-	SearchRandom = SearchAlgo("random")
+	SearchGenetic       = SearchAlgo("genetic")
+	SearchRandom        = SearchAlgo("random")
+	SearchAnnealing     = SearchAlgo("annealing")
+	SearchExact         = SearchAlgo("exact")
 )
 
 type SearchOptions struct {
@@ -22,21 +28,396 @@ type SearchOptions struct {
 	DebugLevel     int
 	SearchDuration time.Duration
 	Debug          *log.Logger
+
+	// Maximum number of possible schedules for SearchExact; 0 means
+	// ExactDefaultLimit
+	ExactLimit float64
+
+	// Take the stored schedule as a baseline, and penalize moving
+	// discussions away from it by ChurnWeight for each affected
+	// person; 0 means ChurnDefaultWeight
+	Incremental bool
+	ChurnWeight int
+
+	// Penalty for each pair of discussions from the same track in
+	// the same slot; 0 means TrackDefaultWeight
+	TrackWeight int
+
+	// Scale each user's interest to the same total before
+	// searching; see normalize.go
+	Normalize bool
+
+	// What to maximize; "" means ObjectiveTotal.  FairnessWeight is
+	// used by ObjectiveBlend; 0 means FairnessDefaultWeight.
+	Objective      Objective
+	FairnessWeight int
+
+	// Seed for the random number generator; 0 means pick one from
+	// the clock.  The seed used is recorded with the schedule.
+	Seed int64
+	// If non-zero, stop after this many iterations (in total, across
+	// all workers) rather than after SearchDuration.  Runs with the
+	// same data, Seed and MaxIterations always give the same
+	// schedule, as long as there is only one worker.
+	MaxIterations int
+
+	// Number of searches to run in parallel; 0 means 1.  Only the
+	// random, genetic and annealing searches use more than one.
+	Workers int
+}
+
+const (
+	ChurnDefaultWeight = InterestMax / 10
+	TrackDefaultWeight = InterestMax
+)
+
+// describe summarizes the options which affect the result of a
+// search, for the schedule version history.
+func (opt *SearchOptions) describe() string {
+	var desc []string
+	switch {
+	case opt.Algo == SearchHeuristicOnly:
+	case opt.MaxIterations > 0:
+		desc = append(desc, fmt.Sprintf("max iterations %d", opt.MaxIterations))
+	default:
+		desc = append(desc, fmt.Sprintf("duration %v", opt.SearchDuration))
+	}
+	if opt.Algo == SearchExact {
+		desc = append(desc, fmt.Sprintf("limit %g", opt.ExactLimit))
+	}
+	if opt.Workers > 1 && opt.Algo != SearchHeuristicOnly && opt.Algo != SearchExact {
+		desc = append(desc, fmt.Sprintf("%d workers", opt.Workers))
+	}
+	if opt.Incremental {
+		desc = append(desc, fmt.Sprintf("incremental (churn weight %d)", opt.ChurnWeight))
+	}
+	if opt.Normalize {
+		desc = append(desc, "normalized interest")
+	}
+	return strings.Join(desc, ", ")
 }
 
 var opt SearchOptions
 
+// SchedProgress describes a search in progress.
+type SchedProgress struct {
+	Algo       SearchAlgo
+	Seed       int64
+	Started    time.Time
+	Iterations int
+	BestScore  int
+}
+
+func (p SchedProgress) Elapsed() string {
+	return formatDuration(time.Since(p.Started))
+}
+
+// formatDuration formats d to the nearest second.  (durafmt can't
+// cope with durations with no non-zero units.)
+func formatDuration(d time.Duration) string {
+	d = d.Truncate(time.Second)
+	if d < time.Second {
+		return "0 seconds"
+	}
+	return durafmt.ParseShort(d).String()
+}
+
+// searchRun tracks the search running in this process, so that its
+// progress can be reported and so that it can be cancelled.
+type searchRun struct {
+	sync.Mutex
+	progress SchedProgress
+	cancel   chan struct{}
+	done     chan struct{} // Closed when the run ends
+
+	// Best schedule offered by any worker; see searchparallel.go
+	best      *schedule
+	bestScore int
+}
+
+var (
+	runLock sync.Mutex
+	curRun  *searchRun
+)
+
+// heartbeat keeps the run's claim on the schedule state fresh until
+// the run ends; see schedRecover.
+func (r *searchRun) heartbeat() {
+	ticker := time.NewTicker(schedHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			schedBeat()
+		}
+	}
+}
+
+// endRun stops the current run's heartbeat, and forgets the run.
+func endRun() {
+	runLock.Lock()
+	close(curRun.done)
+	curRun = nil
+	runLock.Unlock()
+}
+
+func (r *searchRun) cancelled() bool {
+	select {
+	case <-r.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// iterations returns the number of iterations done so far.
+func (r *searchRun) iterations() int {
+	r.Lock()
+	defer r.Unlock()
+	return r.progress.Iterations
+}
+
+// stopped returns true if the search should stop, either because it
+// has used up its budget (opt.MaxIterations if set, otherwise
+// deadline) or because it has been cancelled.
+func (r *searchRun) stopped(deadline time.Time) bool {
+	if r.cancelled() {
+		return true
+	}
+	if opt.MaxIterations > 0 {
+		return r.iterations() >= opt.MaxIterations
+	}
+	return !time.Now().Before(deadline)
+}
+
+// budgetUsed returns the fraction of the search's budget used so far:
+// iterations out of opt.MaxIterations if set, otherwise time since
+// start out of opt.SearchDuration.
+func (r *searchRun) budgetUsed(start time.Time) float64 {
+	if opt.MaxIterations > 0 {
+		return float64(r.iterations()) / float64(opt.MaxIterations)
+	}
+	return float64(time.Since(start)) / float64(opt.SearchDuration)
+}
+
+// report records that iterations more iterations of the search have
+// been done, and that score has been found.
+func (r *searchRun) report(iterations, score int) {
+	r.Lock()
+	r.progress.Iterations += iterations
+	if score > r.progress.BestScore {
+		r.progress.BestScore = score
+	}
+	r.Unlock()
+}
+
+// MakeSchedule runs a search for the best schedule using the
+// algorithm in optArg.Algo, and stores the result.  Only one search
+// may run at a time; errInProgress is returned if one already is.
+// If optArg.Async is set, the search is run in the background, and
+// only errors in starting it are returned.
 func MakeSchedule(optArg SearchOptions) error {
-	// FIXME: Schedule
+	data, err := startSearch(optArg)
+	if err != nil {
+		return err
+	}
+
+	if opt.Async {
+		go func() {
+			if err := runSearch(data); err != nil {
+				log.Printf("Schedule search %s failed: %v", opt.Algo, err)
+			}
+		}()
+		return nil
+	}
+
+	return runSearch(data)
+}
+
+// startSearch fills in the defaults in optArg, claims the right to
+// run a search, and takes a snapshot of the search data.  On success,
+// opt and curRun are set up for the search.
+func startSearch(optArg SearchOptions) (*searchData, error) {
+	switch optArg.Algo {
+	case SearchHeuristicOnly, SearchRandom, SearchGenetic, SearchAnnealing, SearchExact:
+	default:
+		return nil, fmt.Errorf("Unknown search algorithm %s", optArg.Algo)
+	}
+
+	if optArg.Objective == "" {
+		optArg.Objective = ObjectiveTotal
+	}
+	if !optArg.Objective.valid() {
+		return nil, fmt.Errorf("Unknown objective %s", optArg.Objective)
+	}
+	if optArg.Objective == ObjectiveBlend && optArg.FairnessWeight == 0 {
+		optArg.FairnessWeight = FairnessDefaultWeight
+	}
+
+	if optArg.Seed == 0 {
+		optArg.Seed = time.Now().UnixNano()
+	}
+
+	if optArg.Incremental && optArg.ChurnWeight == 0 {
+		optArg.ChurnWeight = ChurnDefaultWeight
+	}
+
+	if optArg.TrackWeight == 0 {
+		optArg.TrackWeight = TrackDefaultWeight
+	}
+
+	if optArg.Debug == nil {
+		optArg.Debug = log.New(ioutil.Discard, "schedule.go ", log.LstdFlags)
+	}
+
+	if err := schedSetRunning(); err != nil {
+		return nil, err
+	}
+
+	data, err := loadSearchData()
+	if err != nil {
+		schedClearRunning()
+		return nil, err
+	}
+
+	if len(data.slots) == 0 {
+		schedClearRunning()
+		return nil, errNoSlots
+	}
+	if len(data.locations) == 0 {
+		schedClearRunning()
+		return nil, errNoLocations
+	}
+	if data.allSlotsLocked() {
+		schedClearRunning()
+		return nil, errAllSlotsLocked
+	}
+
+	if optArg.Normalize {
+		data = data.normalized()
+	}
+
+	opt = optArg
+
+	runLock.Lock()
+	curRun = &searchRun{
+		progress: SchedProgress{Algo: opt.Algo, Seed: opt.Seed, Started: time.Now()},
+		cancel:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go curRun.heartbeat()
+	runLock.Unlock()
+
+	return data, nil
+}
+
+func runSearch(data *searchData) (err error) {
+	defer func() {
+		endRun()
+		if err != nil {
+			schedClearRunning()
+		}
+	}()
+
+	best, err := search(data)
+	if err != nil {
+		return err
+	}
+
+	return best.store()
+}
+
+// search runs the search set up by startSearch, and returns the best
+// schedule found.
+func search(data *searchData) (best *schedule, err error) {
+	opt.Debug.Printf("Scheduling %d discussions into %d slots and %d locations (seed %d)",
+		len(data.discussions), len(data.slots), len(data.locations), opt.Seed)
+
+	switch opt.Algo {
+	case SearchHeuristicOnly:
+		best = heuristicSearch(data)
+	case SearchRandom:
+		best = runWorkers(data, randomSearch)
+	case SearchGenetic:
+		best = runWorkers(data, geneticSearch)
+	case SearchAnnealing:
+		best = runWorkers(data, annealSearch)
+	case SearchExact:
+		best, err = exactSearchSchedule(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if curRun.cancelled() {
+		log.Printf("Schedule search %s cancelled", opt.Algo)
+		return nil, ErrScheduleCancelled
+	}
+
+	if opt.Incremental {
+		log.Printf("Schedule search %s (seed %d): score %d (utility %d, %d discussions moved)",
+			opt.Algo, opt.Seed, best.score(), best.utility(), best.moved())
+	} else {
+		log.Printf("Schedule search %s (seed %d): score %d", opt.Algo, opt.Seed, best.score())
+	}
+	if opt.Objective != ObjectiveTotal {
+		log.Printf("Objective %s: utility %d, worst-off user gets %d%%",
+			opt.describeObjective(), best.utility(), best.minPercent())
+	}
+	if opt.Normalize {
+		log.Printf("Normalized interest: utility %d normalized, %d raw",
+			best.utility(), best.raw().utility())
+	}
+	if overlaps := best.overlaps(); overlaps > 0 {
+		log.Printf("Schedule has %d overlaps between discussions in the same track", overlaps)
+	}
+
+	if opt.Validate {
+		if err := best.validate(); err != nil {
+			return nil, fmt.Errorf("Schedule failed validation: %v", err)
+		}
+	}
+
+	return best, nil
+}
+
+// SchedGetProgress returns the progress of the search running in
+// this process, if any.
+func SchedGetProgress() (SchedProgress, bool) {
+	runLock.Lock()
+	defer runLock.Unlock()
+	if curRun == nil {
+		return SchedProgress{}, false
+	}
+	curRun.Lock()
+	defer curRun.Unlock()
+	return curRun.progress, true
+}
 
-	return errors.New("Not Implemented!")
+// SchedCancel cancels the search running in this process.  The
+// current schedule is left as it was.
+func SchedCancel() error {
+	runLock.Lock()
+	defer runLock.Unlock()
+	if curRun == nil || curRun.cancelled() {
+		return errNotInProgress
+	}
+	close(curRun.cancel)
+	return nil
 }
 
 func SchedLastUpdate() string {
 	lastUpdate := "Never"
-	// if event.ScheduleV2 != nil {
-	// 	lastUpdate = durafmt.ParseShort(time.Since(event.ScheduleV2.Created)).String() + " ago"
-	// }
+	state, err := getSchedState()
+	if err != nil {
+		log.Printf("INTERNAL ERROR: Getting schedule state: %v", err)
+		return lastUpdate
+	}
+	if !state.LastUpdate.IsZero() {
+		lastUpdate = formatDuration(time.Since(state.LastUpdate.Time)) + " ago"
+	}
 	return lastUpdate
 }
 
@@ -49,5 +430,16 @@ const (
 )
 
 func SchedGetState() SchedState {
-	return SchedStateCurrent
+	state, err := getSchedState()
+	switch {
+	case err != nil:
+		log.Printf("INTERNAL ERROR: Getting schedule state: %v", err)
+		return SchedStateModified
+	case state.Running:
+		return SchedStateRunning
+	case state.Generation != state.SchedGeneration:
+		return SchedStateModified
+	default:
+		return SchedStateCurrent
+	}
 }
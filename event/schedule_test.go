@@ -0,0 +1,3098 @@
+package event
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSearchIterations is how long searches run in tests which only
+// check the schedule found is valid: long enough for every algorithm
+// to make some moves, but much quicker than a timed search.
+const testSearchIterations = 200
+
+// testSetupTimetable creates dayCount days of slotCount slots each
+// (with a break after the second slot of each day), and
+// locationCount locations.
+func testSetupTimetable(t *testing.T, dayCount, slotCount, locationCount int) bool {
+	start := time.Date(2020, time.July, 6, 9, 0, 0, 0, event.defaultLocation)
+	for i := 0; i < dayCount; i++ {
+		day := Day{DayName: start.AddDate(0, 0, i).Format("Monday")}
+		if err := NewDay(&day); err != nil {
+			t.Errorf("NewDay: %v", err)
+			return true
+		}
+
+		slotTime := start.AddDate(0, 0, i)
+		for j := 0; j < slotCount; j++ {
+			if j == 2 {
+				slot := Slot{DayID: day.DayID, SlotTime: DBTime{slotTime}, IsBreak: true}
+				if err := NewSlot(&slot); err != nil {
+					t.Errorf("NewSlot (break): %v", err)
+					return true
+				}
+				slotTime = slotTime.Add(30 * time.Minute)
+			}
+			slot := Slot{DayID: day.DayID, SlotTime: DBTime{slotTime}}
+			if err := NewSlot(&slot); err != nil {
+				t.Errorf("NewSlot: %v", err)
+				return true
+			}
+			slotTime = slotTime.Add(time.Hour)
+		}
+	}
+
+	for i := 0; i < locationCount; i++ {
+		loc := Location{
+			LocationName: fmt.Sprintf("Room %d", i+1),
+			IsPlace:      true,
+			Capacity:     10 * (i + 1),
+		}
+		if err := NewLocation(&loc); err != nil {
+			t.Errorf("NewLocation: %v", err)
+			return true
+		}
+		if loc.LocationID != LocationID(i+1) {
+			t.Errorf("Expected location id %d, got %d", i+1, loc.LocationID)
+			return true
+		}
+	}
+
+	return false
+}
+
+// testSetupEvent creates users and public discussions, with random
+// interest.  Each discussion has a different owner, while there are
+// enough users, so that owners don't stop any of them sharing a slot.
+func testSetupEvent(t *testing.T, userCount, discussionCount int) ([]User, []Discussion, bool) {
+	users := make([]User, userCount)
+	for i := range users {
+		subexit := false
+		users[i], subexit = testNewUser(t)
+		if subexit {
+			return nil, nil, true
+		}
+	}
+
+	discussions := make([]Discussion, discussionCount)
+	for i := range discussions {
+		subexit := false
+		var owner UserID
+		if i < len(users) {
+			owner = users[i].UserID
+		}
+		discussions[i], subexit = testNewDiscussion(t, owner)
+		if subexit {
+			return nil, nil, true
+		}
+		if !discussions[i].IsPublic {
+			if err := DiscussionSetPublic(discussions[i].DiscussionID, true); err != nil {
+				t.Errorf("DiscussionSetPublic: %v", err)
+				return nil, nil, true
+			}
+			discussions[i].IsPublic = true
+		}
+	}
+
+	for i := range users {
+		for j := range discussions {
+			if discussions[j].Owner == users[i].UserID || rand.Intn(3) == 0 {
+				continue
+			}
+			if err := users[i].SetInterest(&discussions[j], rand.Intn(InterestMax)+1); err != nil {
+				t.Errorf("SetInterest: %v", err)
+				return nil, nil, true
+			}
+		}
+	}
+
+	return users, discussions, false
+}
+
+// testCheckStoredSchedule checks that the stored schedule has at most
+// one discussion per (slot, location), no discussions in breaks, and
+// that expectedCount discussions are scheduled.
+func testCheckStoredSchedule(t *testing.T, expectedCount int) bool {
+	var entries []struct {
+		DiscussionID DiscussionID
+		SlotID       SlotID
+		LocationID   LocationID
+		IsBreak      bool
+	}
+	err := event.Select(&entries, `
+        select discussionid, slotid, locationid, isbreak
+            from event_schedule natural join event_slots`)
+	if err != nil {
+		t.Errorf("Getting schedule: %v", err)
+		return true
+	}
+
+	if len(entries) != expectedCount {
+		t.Errorf("Expected %d scheduled discussions, got %d", expectedCount, len(entries))
+		return true
+	}
+
+	discussions := make(map[DiscussionID]bool)
+	for _, e := range entries {
+		if e.IsBreak {
+			t.Errorf("Discussion %v scheduled in break %v", e.DiscussionID, e.SlotID)
+			return true
+		}
+		if discussions[e.DiscussionID] {
+			t.Errorf("Discussion %v scheduled twice", e.DiscussionID)
+			return true
+		}
+		discussions[e.DiscussionID] = true
+	}
+
+	return false
+}
+
+func testStoredScore(t *testing.T) (int, bool) {
+	s, _, err := loadSchedule()
+	if err != nil {
+		t.Errorf("Loading schedule: %v", err)
+		return 0, true
+	}
+	return s.score(), false
+}
+
+func testUnitSchedule(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	opt := SearchOptions{Algo: SearchHeuristicOnly}
+
+	t.Logf("Scheduling with no slots")
+	if err := MakeSchedule(opt); err != errNoSlots {
+		t.Errorf("Expected errNoSlots, got %v", err)
+		return
+	}
+
+	// 2 days * 3 slots * 2 locations = 12 places for discussions
+	if testSetupTimetable(t, 2, 3, 2) {
+		return
+	}
+
+	_, discussions, subexit := testSetupEvent(t, 10, 8)
+	if subexit {
+		return
+	}
+
+	t.Logf("Running heuristic search")
+	if err := MakeSchedule(opt); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	if testCheckStoredSchedule(t, len(discussions)) {
+		return
+	}
+
+	for i := range discussions {
+		if _, tm := discussions[i].Slot(); tm == "" {
+			t.Errorf("Discussion %v has no slot", discussions[i].DiscussionID)
+			return
+		}
+		if loc := discussions[i].Location(); loc.LocationID == 0 {
+			t.Errorf("Discussion %v has no location", discussions[i].DiscussionID)
+			return
+		}
+	}
+
+	heuristicScore, subexit := testStoredScore(t)
+	if subexit {
+		return
+	}
+
+	// Random search starts from the heuristic schedule, so should
+	// never do worse.
+	t.Logf("Running random search")
+	if err := MakeSchedule(SearchOptions{Algo: SearchRandom,
+		MaxIterations: testSearchIterations}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	if testCheckStoredSchedule(t, len(discussions)) {
+		return
+	}
+
+	randomScore, subexit := testStoredScore(t)
+	if subexit {
+		return
+	}
+	if randomScore < heuristicScore {
+		t.Errorf("Random search score %d worse than heuristic %d",
+			randomScore, heuristicScore)
+		return
+	}
+
+	t.Logf("Running genetic search")
+	if err := MakeSchedule(SearchOptions{Algo: SearchGenetic,
+		MaxIterations: testSearchIterations}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	if testCheckStoredSchedule(t, len(discussions)) {
+		return
+	}
+
+	// The heuristic schedule is in the initial population, and the
+	// best individuals always survive.
+	geneticScore, subexit := testStoredScore(t)
+	if subexit {
+		return
+	}
+	if geneticScore < heuristicScore {
+		t.Errorf("Genetic search score %d worse than heuristic %d",
+			geneticScore, heuristicScore)
+		return
+	}
+
+	t.Logf("Running annealing search")
+	if err := MakeSchedule(SearchOptions{Algo: SearchAnnealing,
+		MaxIterations: testSearchIterations}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	if testCheckStoredSchedule(t, len(discussions)) {
+		return
+	}
+
+	// Annealing keeps the best schedule seen, starting with the
+	// heuristic one.
+	annealScore, subexit := testStoredScore(t)
+	if subexit {
+		return
+	}
+	if annealScore < heuristicScore {
+		t.Errorf("Annealing search score %d worse than heuristic %d",
+			annealScore, heuristicScore)
+		return
+	}
+
+	tt := GetTimetable()
+	if len(tt.Days) != 2 {
+		t.Errorf("Expected 2 days in timetable, got %d", len(tt.Days))
+		return
+	}
+	count := 0
+	for _, day := range tt.Days {
+		if len(day.Slots) != 4 {
+			t.Errorf("Expected 4 slots in %s, got %d", day.DayName, len(day.Slots))
+			return
+		}
+		for _, slot := range day.Slots {
+			if slot.IsBreak && len(slot.Discussions) > 0 {
+				t.Errorf("Discussions scheduled in break")
+				return
+			}
+			count += len(slot.Discussions)
+		}
+	}
+	if count != len(discussions) {
+		t.Errorf("Expected %d discussions in timetable, got %d", len(discussions), count)
+		return
+	}
+
+	// More discussions than places: some must be left out
+	t.Logf("Scheduling with too many discussions")
+	for i := 0; i < 6; i++ {
+		disc, subexit := testNewDiscussion(t, "")
+		if subexit {
+			return
+		}
+		if err := DiscussionSetPublic(disc.DiscussionID, true); err != nil {
+			t.Errorf("DiscussionSetPublic: %v", err)
+			return
+		}
+	}
+
+	if err := MakeSchedule(opt); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	if testCheckStoredSchedule(t, 12) {
+		return
+	}
+
+	// Deleting a scheduled discussion should remove it from the schedule
+	if err := DeleteDiscussion(discussions[0].DiscussionID); err != nil {
+		t.Errorf("Deleting scheduled discussion: %v", err)
+		return
+	}
+	if err := event.Get(&count, `
+        select count(*) from event_schedule where discussionid = ?`,
+		discussions[0].DiscussionID); err != nil || count != 0 {
+		t.Errorf("Expected deleted discussion unscheduled, got %d entries (error %v)", count, err)
+		return
+	}
+	for _, day := range GetTimetable().Days {
+		for _, slot := range day.Slots {
+			for _, disc := range slot.Discussions {
+				if disc.DiscussionID == discussions[0].DiscussionID {
+					t.Errorf("Deleted discussion still in timetable slot %s", slot.Time)
+					return
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// testUnitScheduleExact uses the exact search on a small event as
+// ground truth for the other search algorithms.
+func testUnitScheduleExact(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 1 day * 3 slots * 2 locations; 5 discussions gives 4^5 possible
+	// schedules
+	if testSetupTimetable(t, 1, 3, 2) {
+		return
+	}
+
+	_, discussions, subexit := testSetupEvent(t, 8, 5)
+	if subexit {
+		return
+	}
+
+	t.Logf("Running exact search with too small a limit")
+	if err := MakeSchedule(SearchOptions{Algo: SearchExact, ExactLimit: 1000,
+		SearchDuration: time.Second}); err != ErrSearchSpaceTooLarge {
+		t.Errorf("Expected ErrSearchSpaceTooLarge, got %v", err)
+		return
+	}
+
+	t.Logf("Running exact search")
+	if err := MakeSchedule(SearchOptions{Algo: SearchExact,
+		SearchDuration: time.Minute}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	if testCheckStoredSchedule(t, len(discussions)) {
+		return
+	}
+
+	exactScore, subexit := testStoredScore(t)
+	if subexit {
+		return
+	}
+
+	for _, algo := range []SearchAlgo{SearchHeuristicOnly, SearchRandom, SearchGenetic, SearchAnnealing} {
+		if err := MakeSchedule(SearchOptions{Algo: algo,
+			MaxIterations: testSearchIterations}); err != nil {
+			t.Errorf("MakeSchedule(%s): %v", algo, err)
+			return
+		}
+		score, subexit := testStoredScore(t)
+		if subexit {
+			return
+		}
+		t.Logf("%s: score %d (optimal %d)", algo, score, exactScore)
+		if score > exactScore {
+			t.Errorf("%s found score %d better than optimal %d", algo, score, exactScore)
+			return
+		}
+	}
+
+	return false
+}
+
+func testExpectSchedState(t *testing.T, expected SchedState) bool {
+	if state := SchedGetState(); state != expected {
+		t.Errorf("Expected schedule state %d, got %d", expected, state)
+		return true
+	}
+	return false
+}
+
+// testWaitSchedState waits up to a few seconds for the schedule to
+// reach a given state.
+func testWaitSchedState(t *testing.T, expected SchedState) bool {
+	for i := 0; i < 100; i++ {
+		if SchedGetState() == expected {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return testExpectSchedState(t, expected)
+}
+
+func testUnitScheduleState(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	if testExpectSchedState(t, SchedStateCurrent) {
+		return
+	}
+	if lu := SchedLastUpdate(); lu != "Never" {
+		t.Errorf("Expected last update Never, got %s", lu)
+		return
+	}
+
+	if testSetupTimetable(t, 2, 3, 2) {
+		return
+	}
+
+	users, discussions, subexit := testSetupEvent(t, 10, 8)
+	if subexit {
+		return
+	}
+
+	if testExpectSchedState(t, SchedStateModified) {
+		return
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	if testExpectSchedState(t, SchedStateCurrent) {
+		return
+	}
+	if lu := SchedLastUpdate(); lu == "Never" {
+		t.Errorf("Last update not set after schedule")
+		return
+	}
+
+	t.Logf("Changing interest should make the schedule stale")
+	if err := users[0].SetInterest(&discussions[0], InterestMax); err != nil {
+		t.Errorf("SetInterest: %v", err)
+		return
+	}
+	if testExpectSchedState(t, SchedStateModified) {
+		return
+	}
+
+	t.Logf("Running and cancelling an asynchronous search")
+	if err := MakeSchedule(SearchOptions{Algo: SearchRandom, Async: true,
+		SearchDuration: time.Minute}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	if testExpectSchedState(t, SchedStateRunning) {
+		return
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly}); err != errInProgress {
+		t.Errorf("Expected errInProgress, got %v", err)
+		return
+	}
+
+	if progress, ok := SchedGetProgress(); !ok {
+		t.Errorf("No progress for running search")
+		return
+	} else if progress.Algo != SearchRandom {
+		t.Errorf("Expected progress for %s, got %s", SearchRandom, progress.Algo)
+		return
+	}
+
+	if err := SchedCancel(); err != nil {
+		t.Errorf("SchedCancel: %v", err)
+		return
+	}
+
+	// The cancelled search shouldn't have replaced the schedule
+	if testWaitSchedState(t, SchedStateModified) {
+		return
+	}
+
+	if err := SchedCancel(); err != errNotInProgress {
+		t.Errorf("Expected errNotInProgress, got %v", err)
+		return
+	}
+
+	t.Logf("Leaving a running search alone")
+	if err := schedSetRunning(); err != nil {
+		t.Errorf("schedSetRunning: %v", err)
+		return
+	}
+	Close()
+	if err := Load(EventOptions{dbFilename: tc.dbfname, DefaultLocation: TestDefaultLocation}); err != nil {
+		t.Errorf("Reloading: %v", err)
+		return
+	}
+	if testExpectSchedState(t, SchedStateRunning) {
+		return
+	}
+
+	t.Logf("Recovering from a search interrupted by a crash")
+	if _, err := event.Exec(`update event_schedule_state set heartbeat = ?`,
+		DBTime{time.Now().Add(-2 * schedLease)}); err != nil {
+		t.Errorf("Setting heartbeat: %v", err)
+		return
+	}
+	Close()
+	if err := Load(EventOptions{dbFilename: tc.dbfname, DefaultLocation: TestDefaultLocation}); err != nil {
+		t.Errorf("Reloading: %v", err)
+		return
+	}
+	if testExpectSchedState(t, SchedStateModified) {
+		return
+	}
+
+	return false
+}
+
+func testCheckOwnerConflicts(t *testing.T) bool {
+	var conflicts int
+	err := event.Get(&conflicts, `
+        select count(*) from (
+            select owner, slotid
+                from event_schedule natural join event_discussions
+                group by owner, slotid
+                having count(*) > 1)`)
+	if err != nil {
+		t.Errorf("Checking for owner conflicts: %v", err)
+		return true
+	}
+	if conflicts > 0 {
+		t.Errorf("%d owner conflicts in stored schedule", conflicts)
+		return true
+	}
+	return false
+}
+
+func testUnitScheduleOwnerConflicts(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 1 day * 3 slots * 3 locations
+	if testSetupTimetable(t, 1, 3, 3) {
+		return
+	}
+
+	users, discussions, subexit := testSetupEvent(t, 6, 3)
+	if subexit {
+		return
+	}
+
+	// A user who doesn't own any discussions yet proposes three
+	// which everyone is very interested in
+	owners := make(map[UserID]bool)
+	for _, d := range discussions {
+		owners[d.Owner] = true
+	}
+	var owner User
+	for _, u := range users {
+		if !owners[u.UserID] {
+			owner = u
+			break
+		}
+	}
+	ownerDiscussions := make([]Discussion, 3)
+	for i := range ownerDiscussions {
+		ownerDiscussions[i], subexit = testNewDiscussion(t, owner.UserID)
+		if subexit {
+			return
+		}
+		if err := DiscussionSetPublic(ownerDiscussions[i].DiscussionID, true); err != nil {
+			t.Errorf("DiscussionSetPublic: %v", err)
+			return
+		}
+		for j := range users {
+			if err := users[j].SetInterest(&ownerDiscussions[i], InterestMax); err != nil {
+				t.Errorf("SetInterest: %v", err)
+				return
+			}
+		}
+	}
+
+	for _, algo := range []SearchAlgo{SearchHeuristicOnly, SearchRandom, SearchGenetic, SearchAnnealing, SearchExact} {
+		t.Logf("Checking owner conflicts for %s", algo)
+		if err := MakeSchedule(SearchOptions{Algo: algo, Validate: true,
+			MaxIterations: testSearchIterations}); err != nil {
+			t.Errorf("MakeSchedule(%s): %v", algo, err)
+			return
+		}
+		if testCheckStoredSchedule(t, 6) || testCheckOwnerConflicts(t) {
+			return
+		}
+	}
+
+	t.Logf("Checking that validation catches owner conflicts")
+	s, _, err := loadSchedule()
+	if err != nil {
+		t.Errorf("loadSchedule: %v", err)
+		return
+	}
+	if err := s.validate(); err != nil {
+		t.Errorf("Stored schedule failed validation: %v", err)
+		return
+	}
+	var od []int
+	for d := range s.data.discussions {
+		if s.data.users[s.data.discussions[d].owner] == owner.UserID {
+			od = append(od, d)
+		}
+	}
+	s.unassign(od[1])
+	s.assign(od[1], s.slotOf[od[0]])
+	if err := s.validate(); err == nil {
+		t.Errorf("Validation didn't catch owner conflict")
+		return
+	}
+
+	return false
+}
+
+// testUnitSchedulePlacement checks that within each slot, the
+// discussions with the largest expected audience get the largest
+// locations, and that discussions which won't fit are flagged.
+func testUnitSchedulePlacement(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 1 day * 2 slots * 3 locations, capacities 10, 20, 30
+	if testSetupTimetable(t, 1, 2, 3) {
+		return
+	}
+
+	if _, _, subexit := testSetupEvent(t, 40, 6); subexit {
+		return
+	}
+
+	// Some discussions are expected to overflow their locations,
+	// which validation counts as violations; check them below
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	if testCheckStoredSchedule(t, 6) {
+		return
+	}
+
+	tt := GetTimetable()
+	overflows := 0
+	for _, day := range tt.Days {
+		for _, slot := range day.Slots {
+			for i, disc := range slot.Discussions {
+				if disc.IsOverflow != (disc.Attendees > disc.LocationInfo.Capacity) {
+					t.Errorf("Discussion %v: %d attendees, capacity %d, but IsOverflow %v",
+						disc.DiscussionID, disc.Attendees, disc.LocationInfo.Capacity,
+						disc.IsOverflow)
+					return
+				}
+				if disc.IsOverflow {
+					overflows++
+				}
+				for _, other := range slot.Discussions[i+1:] {
+					if (other.LocationInfo.Capacity > disc.LocationInfo.Capacity) !=
+						(other.Attendees > disc.Attendees) &&
+						other.Attendees != disc.Attendees {
+						t.Errorf("Slot %s: discussion %v (%d attendees) in capacity %d, but %v (%d attendees) in capacity %d",
+							slot.Time, disc.DiscussionID, disc.Attendees, disc.LocationInfo.Capacity,
+							other.DiscussionID, other.Attendees, other.LocationInfo.Capacity)
+						return
+					}
+				}
+			}
+		}
+	}
+
+	if len(tt.Overflows()) != overflows {
+		t.Errorf("Expected %d overflows, Overflows() returned %d", overflows, len(tt.Overflows()))
+		return
+	}
+	if violations, _, err := ValidateSchedule(); err != nil || len(violations) != overflows {
+		t.Errorf("Expected %d overflow violations, got %v (error %v)", overflows, violations, err)
+		return
+	}
+
+	return false
+}
+
+// testUnitScheduleLocked checks that discussions in locked slots stay
+// where they are, and that nothing new is added to them.
+func testUnitScheduleLocked(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 2 days * 2 slots * 2 locations
+	if testSetupTimetable(t, 2, 2, 2) {
+		return
+	}
+
+	users, _, subexit := testSetupEvent(t, 10, 6)
+	if subexit {
+		return
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly, Validate: true}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	if err := LockedSlotsSet([]SlotID{"nonexistent"}, nil); err != ErrSlotNotFound {
+		t.Errorf("Expected ErrSlotNotFound, got %v", err)
+		return
+	}
+	if err := LockedSlotsSet(nil, []DayID{100}); err != ErrDayNotFound {
+		t.Errorf("Expected ErrDayNotFound, got %v", err)
+		return
+	}
+
+	days, err := DayGetAll()
+	if err != nil {
+		t.Errorf("DayGetAll: %v", err)
+		return
+	}
+	if err := LockedSlotsSet(nil, []DayID{days[0].DayID}); err != nil {
+		t.Errorf("LockedSlotsSet: %v", err)
+		return
+	}
+
+	for i, ds := range TimetableGetLockedSlots() {
+		if ds.Checked != (i < 2) {
+			t.Errorf("Slot %d (%s): expected locked %v, got %v", i, ds.Label, i < 2, ds.Checked)
+			return
+		}
+	}
+	if dd := TimetableGetLockedDays(); !dd[0].Checked || dd[1].Checked {
+		t.Errorf("Unexpected locked days %v", dd)
+		return
+	}
+
+	lockedSlots := make(map[SlotID]bool)
+	slots, err := SlotGetAll()
+	if err != nil {
+		t.Errorf("SlotGetAll: %v", err)
+		return
+	}
+	for _, slot := range slots {
+		if slot.IsLocked {
+			if slot.IsBreak || slot.DayID != days[0].DayID {
+				t.Errorf("Unexpected locked slot %v", slot)
+				return
+			}
+			lockedSlots[slot.SlotID] = true
+		}
+	}
+
+	lockedEntries := func() (entries map[DiscussionID]scheduleEntry, exit bool) {
+		_, all, err := loadSchedule()
+		if err != nil {
+			t.Errorf("loadSchedule: %v", err)
+			return nil, true
+		}
+		entries = make(map[DiscussionID]scheduleEntry)
+		for _, e := range all {
+			if lockedSlots[e.SlotID] {
+				entries[e.DiscussionID] = e
+			}
+		}
+		return entries, false
+	}
+
+	before, subexit := lockedEntries()
+	if subexit {
+		return
+	}
+
+	// Add a popular discussion, which would otherwise be likely to
+	// displace something
+	disc, subexit := testNewDiscussion(t, "")
+	if subexit {
+		return
+	}
+	if err := DiscussionSetPublic(disc.DiscussionID, true); err != nil {
+		t.Errorf("DiscussionSetPublic: %v", err)
+		return
+	}
+	for i := range users {
+		if users[i].UserID == disc.Owner {
+			continue
+		}
+		if err := users[i].SetInterest(&disc, InterestMax); err != nil {
+			t.Errorf("SetInterest: %v", err)
+			return
+		}
+	}
+
+	for _, algo := range []SearchAlgo{SearchHeuristicOnly, SearchRandom, SearchGenetic, SearchAnnealing, SearchExact} {
+		t.Logf("Checking locked slots for %s", algo)
+		if err := MakeSchedule(SearchOptions{Algo: algo, Validate: true,
+			MaxIterations: testSearchIterations}); err != nil {
+			t.Errorf("MakeSchedule(%s): %v", algo, err)
+			return
+		}
+		after, subexit := lockedEntries()
+		if subexit {
+			return
+		}
+		if len(after) != len(before) {
+			t.Errorf("%s: expected %d discussions in locked slots, got %d",
+				algo, len(before), len(after))
+			return
+		}
+		for did, e := range before {
+			if after[did] != e {
+				t.Errorf("%s: locked discussion %v moved from %v to %v", algo, did, e, after[did])
+				return
+			}
+		}
+	}
+
+	if err := LockedSlotsSet(nil, []DayID{days[0].DayID, days[1].DayID}); err != nil {
+		t.Errorf("LockedSlotsSet: %v", err)
+		return
+	}
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly}); err != errAllSlotsLocked {
+		t.Errorf("Expected errAllSlotsLocked, got %v", err)
+		return
+	}
+
+	return false
+}
+
+// testUnitScheduleIncremental checks that rescheduling incrementally
+// after a new discussion is added doesn't move existing discussions
+// when there's room for the new one.
+func testUnitScheduleIncremental(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 1 day * 3 slots * 3 locations, with room to spare
+	if testSetupTimetable(t, 1, 3, 3) {
+		return
+	}
+
+	users, _, subexit := testSetupEvent(t, 10, 6)
+	if subexit {
+		return
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly, Validate: true}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	_, baseline, err := loadSchedule()
+	if err != nil {
+		t.Errorf("loadSchedule: %v", err)
+		return
+	}
+
+	disc, subexit := testNewDiscussion(t, "")
+	if subexit {
+		return
+	}
+	if err := DiscussionSetPublic(disc.DiscussionID, true); err != nil {
+		t.Errorf("DiscussionSetPublic: %v", err)
+		return
+	}
+	for i := range users {
+		if users[i].UserID == disc.Owner {
+			continue
+		}
+		if err := users[i].SetInterest(&disc, InterestMax); err != nil {
+			t.Errorf("SetInterest: %v", err)
+			return
+		}
+	}
+
+	for _, algo := range []SearchAlgo{SearchHeuristicOnly, SearchRandom, SearchGenetic, SearchAnnealing, SearchExact} {
+		t.Logf("Checking incremental rescheduling for %s", algo)
+		if err := MakeSchedule(SearchOptions{Algo: algo, Validate: true,
+			MaxIterations: testSearchIterations,
+			Incremental:   true, ChurnWeight: InterestMax * 10}); err != nil {
+			t.Errorf("MakeSchedule(%s): %v", algo, err)
+			return
+		}
+
+		_, entries, err := loadSchedule()
+		if err != nil {
+			t.Errorf("loadSchedule: %v", err)
+			return
+		}
+		if len(entries) != len(baseline)+1 {
+			t.Errorf("%s: expected %d scheduled discussions, got %d",
+				algo, len(baseline)+1, len(entries))
+			return
+		}
+		after := make(map[DiscussionID]scheduleEntry)
+		for _, e := range entries {
+			after[e.DiscussionID] = e
+		}
+		for _, e := range baseline {
+			if after[e.DiscussionID] != e {
+				t.Errorf("%s: discussion %v moved from %v to %v",
+					algo, e.DiscussionID, e, after[e.DiscussionID])
+				return
+			}
+		}
+	}
+
+	return false
+}
+
+// testUnitScheduleValidate checks that ValidateSchedule finds each
+// kind of problem in the stored schedule.
+func testUnitScheduleValidate(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 1 day * 3 slots * 2 locations
+	if testSetupTimetable(t, 1, 3, 2) {
+		return
+	}
+
+	if _, _, subexit := testSetupEvent(t, 10, 4); subexit {
+		return
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly, Validate: true}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	violations, _, err := ValidateSchedule()
+	if err != nil {
+		t.Errorf("ValidateSchedule: %v", err)
+		return
+	}
+	if len(violations) != 0 {
+		t.Errorf("Unexpected violations: %v", violations)
+		return
+	}
+
+	var entries []struct {
+		DiscussionID DiscussionID
+		SlotID       SlotID
+		LocationID   LocationID
+		Owner        UserID
+	}
+	if err := event.Select(&entries, `
+        select discussionid, slotid, locationid, owner
+            from event_schedule natural join event_discussions
+            order by discussionid`); err != nil {
+		t.Errorf("Getting schedule: %v", err)
+		return
+	}
+	var breakSlot SlotID
+	if err := event.Get(&breakSlot, `select slotid from event_slots where isbreak = true`); err != nil {
+		t.Errorf("Getting break: %v", err)
+		return
+	}
+
+	// Each corruption is undone before the next
+	tests := []struct {
+		desc    string
+		corrupt string
+		fix     string
+		args    []interface{}
+	}{
+		{"discussion in break",
+			`update event_schedule set slotid = ? where discussionid = ?`,
+			`update event_schedule set slotid = ? where discussionid = ?`,
+			[]interface{}{breakSlot, entries[0].DiscussionID, entries[0].SlotID, entries[0].DiscussionID}},
+		{"owner conflict",
+			`update event_discussions set owner = ? where discussionid = ?`,
+			`update event_discussions set owner = ? where discussionid = ?`,
+			nil},
+		{"impossible slot",
+			`insert into event_discussions_possible_slots(discussionid, slotid) values(?, ?)`,
+			`delete from event_discussions_possible_slots where discussionid = ? and slotid = ?`,
+			[]interface{}{entries[0].DiscussionID, breakSlot, entries[0].DiscussionID, breakSlot}},
+		{"non-contiguous slots",
+			`update event_slots set slotidx = slotidx + 10 where slotid = ?`,
+			`update event_slots set slotidx = slotidx - 10 where slotid = ?`,
+			[]interface{}{breakSlot, breakSlot}},
+		{"location not a place",
+			`update event_locations set isplace = false where locationid = ?`,
+			`update event_locations set isplace = true where locationid = ?`,
+			[]interface{}{entries[0].LocationID, entries[0].LocationID}},
+	}
+
+	// Four discussions in three slots means at least two share a
+	// slot; give them the same owner
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			if entries[j].SlotID == entries[i].SlotID {
+				tests[1].args = []interface{}{entries[i].Owner, entries[j].DiscussionID,
+					entries[j].Owner, entries[j].DiscussionID}
+			}
+		}
+	}
+	if tests[1].args == nil {
+		t.Errorf("Couldn't find two discussions in the same slot: %v", entries)
+		return
+	}
+
+	for _, test := range tests {
+		t.Logf("Checking validation catches %s", test.desc)
+		n := len(test.args) / 2
+		if _, err := event.Exec(test.corrupt, test.args[:n]...); err != nil {
+			t.Errorf("%s: breaking schedule: %v", test.desc, err)
+			return
+		}
+		violations, _, err := ValidateSchedule()
+		if err != nil {
+			t.Errorf("%s: ValidateSchedule: %v", test.desc, err)
+			return
+		}
+		if len(violations) == 0 {
+			t.Errorf("%s: no violations found", test.desc)
+			return
+		}
+		t.Logf("  Found %v", violations)
+		if _, err := event.Exec(test.fix, test.args[n:]...); err != nil {
+			t.Errorf("%s: fixing schedule: %v", test.desc, err)
+			return
+		}
+	}
+
+	t.Logf("Checking validation catches a scheduled discussion which wasn't stored")
+	stored, _, err := loadSchedule()
+	if err != nil {
+		t.Errorf("loadSchedule: %v", err)
+		return
+	}
+	if _, err := event.Exec(`delete from event_schedule where discussionid = ?`,
+		entries[0].DiscussionID); err != nil {
+		t.Errorf("Unscheduling discussion: %v", err)
+		return
+	}
+	violations, _, err = validateScheduleTx(event, stored)
+	if err != nil {
+		t.Errorf("validateScheduleTx: %v", err)
+		return
+	}
+	if len(violations) != 1 || !strings.Contains(violations[0], "isn't stored") {
+		t.Errorf("Expected one violation for the unstored discussion, got %v", violations)
+		return
+	}
+
+	t.Logf("Checking validation catches discussions over capacity")
+	if _, err := event.Exec(`update event_locations set capacity = 0`); err != nil {
+		t.Errorf("Setting capacity: %v", err)
+		return
+	}
+	violations, _, err = ValidateSchedule()
+	if err != nil {
+		t.Errorf("ValidateSchedule: %v", err)
+		return
+	}
+	if len(violations) == 0 {
+		t.Errorf("Expected capacity violations, got none")
+		return
+	}
+	for _, v := range violations {
+		if !strings.Contains(v, "only holds") {
+			t.Errorf("Expected only capacity violations, got %v", violations)
+			return
+		}
+	}
+
+	return false
+}
+
+// testUnitScheduleReport checks that the per-user reports agree with
+// the schedule score.
+func testUnitScheduleReport(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 1 day * 3 slots * 2 locations
+	if testSetupTimetable(t, 1, 3, 2) {
+		return
+	}
+
+	users, _, subexit := testSetupEvent(t, 10, 6)
+	if subexit {
+		return
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly, Validate: true}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	score, subexit := testStoredScore(t)
+	if subexit {
+		return
+	}
+
+	reports, err := UserReportGetAll()
+	if err != nil {
+		t.Errorf("UserReportGetAll: %v", err)
+		return
+	}
+
+	total, interested := 0, 0
+	for _, r := range reports {
+		total += r.Utility
+		if r.MaxScore > 0 {
+			interested++
+		}
+
+		attending := make(map[string]bool)
+		utility := 0
+		for _, rd := range r.Discussions {
+			if rd.IsAttending {
+				if attending[rd.Time] {
+					t.Errorf("User %v attending two discussions at %s", r.UserID, rd.Time)
+					return
+				}
+				attending[rd.Time] = true
+				utility += rd.Interest
+			} else if rd.Time != "" && rd.ClashID == "" {
+				t.Errorf("User %v not attending %v, but no clash", r.UserID, rd.DiscussionID)
+				return
+			}
+		}
+		if utility != r.Utility {
+			t.Errorf("User %v: utility %d, but attending discussions worth %d",
+				r.UserID, r.Utility, utility)
+			return
+		}
+	}
+	if total != score {
+		t.Errorf("Sum of user utility %d, but schedule score %d", total, score)
+		return
+	}
+
+	for i := range users {
+		r, err := UserReportGet(users[i].UserID)
+		if err != nil {
+			t.Errorf("UserReportGet: %v", err)
+			return
+		}
+		maxScore, err := users[i].GetMaxScore()
+		if err != nil {
+			t.Errorf("GetMaxScore: %v", err)
+			return
+		}
+		if r.MaxScore != maxScore {
+			t.Errorf("User %v: report max score %d, GetMaxScore %d",
+				users[i].UserID, r.MaxScore, maxScore)
+			return
+		}
+	}
+
+	if _, err := UserReportGet("nonexistent"); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+		return
+	}
+
+	dist, err := UtilityDistributionGet()
+	if err != nil {
+		t.Errorf("UtilityDistributionGet: %v", err)
+		return
+	}
+	count := 0
+	for _, b := range dist.Buckets {
+		count += b.Count
+	}
+	if dist.Users != interested || count != interested {
+		t.Errorf("Expected %d users in distribution, got %d (%d in buckets)",
+			interested, dist.Users, count)
+		return
+	}
+
+	return false
+}
+
+// testUnitScheduleVersions checks that each stored schedule is kept
+// as a version, that versions can be compared, and that rolling back
+// restores an earlier schedule.
+func testUnitScheduleVersions(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 1 day * 3 slots * 3 locations
+	if testSetupTimetable(t, 1, 3, 3) {
+		return
+	}
+
+	if _, _, subexit := testSetupEvent(t, 10, 5); subexit {
+		return
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	_, v1, err := loadSchedule()
+	if err != nil {
+		t.Errorf("loadSchedule: %v", err)
+		return
+	}
+
+	disc, subexit := testNewDiscussion(t, "")
+	if subexit {
+		return
+	}
+	if err := DiscussionSetPublic(disc.DiscussionID, true); err != nil {
+		t.Errorf("DiscussionSetPublic: %v", err)
+		return
+	}
+	if err := MakeSchedule(SearchOptions{Algo: SearchRandom,
+		MaxIterations: testSearchIterations}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	versions, err := ScheduleVersionGetAll()
+	if err != nil {
+		t.Errorf("ScheduleVersionGetAll: %v", err)
+		return
+	}
+	if len(versions) != 2 || versions[0].Version != 2 || versions[1].Version != 1 ||
+		versions[0].Algo != string(SearchRandom) || versions[1].Algo != string(SearchHeuristicOnly) {
+		t.Errorf("Unexpected versions %v", versions)
+		return
+	}
+
+	diff, err := ScheduleVersionDiff(1, 2)
+	if err != nil {
+		t.Errorf("ScheduleVersionDiff: %v", err)
+		return
+	}
+	if len(diff.Added) != 1 || diff.Added[0].DiscussionID != disc.DiscussionID ||
+		len(diff.Removed) != 0 {
+		t.Errorf("Unexpected diff %v", diff)
+		return
+	}
+
+	if diff, err = ScheduleVersionDiff(2, 2); err != nil {
+		t.Errorf("ScheduleVersionDiff: %v", err)
+		return
+	}
+	if len(diff.Moved)+len(diff.Added)+len(diff.Removed) != 0 {
+		t.Errorf("Expected no differences, got %v", diff)
+		return
+	}
+
+	if _, err = ScheduleVersionDiff(1, 99); err != ErrVersionNotFound {
+		t.Errorf("Expected ErrVersionNotFound, got %v", err)
+		return
+	}
+	if err = ScheduleRollback(99); err != ErrVersionNotFound {
+		t.Errorf("Expected ErrVersionNotFound, got %v", err)
+		return
+	}
+
+	if err = ScheduleRollback(1); err != nil {
+		t.Errorf("ScheduleRollback: %v", err)
+		return
+	}
+	_, rolledBack, err := loadSchedule()
+	if err != nil {
+		t.Errorf("loadSchedule: %v", err)
+		return
+	}
+	if len(rolledBack) != len(v1) {
+		t.Errorf("Expected %d entries after rollback, got %d", len(v1), len(rolledBack))
+		return
+	}
+	for i := range v1 {
+		if rolledBack[i] != v1[i] {
+			t.Errorf("Expected %v after rollback, got %v", v1[i], rolledBack[i])
+			return
+		}
+	}
+	if testExpectSchedState(t, SchedStateModified) {
+		return
+	}
+
+	if diff, err = ScheduleVersionDiff(1, 3); err != nil {
+		t.Errorf("ScheduleVersionDiff: %v", err)
+		return
+	}
+	if len(diff.Moved)+len(diff.Added)+len(diff.Removed) != 0 {
+		t.Errorf("Expected rollback to match version 1, got %v", diff)
+		return
+	}
+
+	// History is kept when discussions are deleted
+	if err = DeleteDiscussion(v1[0].DiscussionID); err != nil {
+		t.Errorf("DeleteDiscussion: %v", err)
+		return
+	}
+	if diff, err = ScheduleVersionDiff(1, 3); err != nil {
+		t.Errorf("ScheduleVersionDiff: %v", err)
+		return
+	}
+	if len(diff.Moved)+len(diff.Added)+len(diff.Removed) != 0 {
+		t.Errorf("Expected history to be kept, got %v", diff)
+		return
+	}
+
+	return false
+}
+
+// testUnitScheduleSeed checks that searches with the same seed and
+// iteration limit give the same schedule, and that the seed is
+// recorded.
+func testUnitScheduleSeed(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 2 days * 3 slots * 2 locations
+	if testSetupTimetable(t, 2, 3, 2) {
+		return
+	}
+
+	if _, _, subexit := testSetupEvent(t, 12, 10); subexit {
+		return
+	}
+
+	const seed = 42
+	const maxIterations = 3000
+
+	for _, algo := range []SearchAlgo{SearchRandom, SearchGenetic, SearchAnnealing, SearchExact} {
+		t.Logf("Checking %s is reproducible", algo)
+		var runs [2][]scheduleEntry
+		for i := range runs {
+			if err := MakeSchedule(SearchOptions{Algo: algo, Seed: seed,
+				MaxIterations: maxIterations, SearchDuration: time.Millisecond}); err != nil {
+				t.Errorf("MakeSchedule(%s): %v", algo, err)
+				return
+			}
+			_, entries, err := loadSchedule()
+			if err != nil {
+				t.Errorf("loadSchedule: %v", err)
+				return
+			}
+			runs[i] = entries
+		}
+
+		versions, err := ScheduleVersionGetAll()
+		if err != nil {
+			t.Errorf("ScheduleVersionGetAll: %v", err)
+			return
+		}
+		if versions[0].Seed != seed || versions[0].Iterations != versions[1].Iterations {
+			t.Errorf("%s: unexpected versions %v", algo, versions[:2])
+			return
+		}
+
+		if len(runs[0]) != len(runs[1]) {
+			t.Errorf("%s: runs scheduled %d and %d discussions", algo, len(runs[0]), len(runs[1]))
+			return
+		}
+		for i := range runs[0] {
+			if runs[0][i] != runs[1][i] {
+				t.Errorf("%s: runs differ: %v vs %v", algo, runs[0][i], runs[1][i])
+				return
+			}
+		}
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchRandom, SearchDuration: 10 * time.Millisecond}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	versions, err := ScheduleVersionGetAll()
+	if err != nil {
+		t.Errorf("ScheduleVersionGetAll: %v", err)
+		return
+	}
+	if versions[0].Seed == 0 {
+		t.Errorf("Seed chosen from the clock not recorded")
+		return
+	}
+
+	return false
+}
+
+// testUnitScheduleWorkers runs the parallel searches, checking that
+// they give valid schedules at least as good as the heuristic.
+func testUnitScheduleWorkers(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 2 days * 3 slots * 2 locations
+	if testSetupTimetable(t, 2, 3, 2) {
+		return
+	}
+
+	// No more users than the smallest location holds, so that
+	// validation can't fail on an overflow
+	if _, _, subexit := testSetupEvent(t, 10, 10); subexit {
+		return
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	heuristicScore, subexit := testStoredScore(t)
+	if subexit {
+		return
+	}
+
+	for _, algo := range []SearchAlgo{SearchRandom, SearchGenetic, SearchAnnealing} {
+		t.Logf("Running %s with 4 workers", algo)
+		if err := MakeSchedule(SearchOptions{Algo: algo, Validate: true, Workers: 4,
+			MaxIterations: testSearchIterations}); err != nil {
+			t.Errorf("MakeSchedule(%s): %v", algo, err)
+			return
+		}
+		if testCheckStoredSchedule(t, 10) || testCheckOwnerConflicts(t) {
+			return
+		}
+		score, subexit := testStoredScore(t)
+		if subexit {
+			return
+		}
+		if score < heuristicScore {
+			t.Errorf("%s: score %d worse than heuristic %d", algo, score, heuristicScore)
+			return
+		}
+	}
+
+	return false
+}
+
+func testUnitSchedulePossible(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 2 days * 2 slots * 2 locations
+	if testSetupTimetable(t, 2, 2, 2) {
+		return
+	}
+
+	_, discussions, subexit := testSetupEvent(t, 10, 6)
+	if subexit {
+		return
+	}
+
+	var slots []SlotID
+	for _, ds := range TimetableGetPossibleSlots(discussions[0].DiscussionID) {
+		if !ds.Checked {
+			t.Errorf("Slot %s not possible for unrestricted discussion", ds.Label)
+			return
+		}
+		slots = append(slots, ds.SlotID)
+	}
+	if len(slots) != 4 {
+		t.Errorf("Expected 4 slots, got %d", len(slots))
+		return
+	}
+
+	if err := DiscussionSetPossibleSlots(discussions[0].DiscussionID, nil); !IsValidationError(err) {
+		t.Errorf("Expected validation error for no possible slots, got %v", err)
+		return
+	}
+	if err := DiscussionSetPossibleSlots(discussions[0].DiscussionID, []SlotID{"nonexistent"}); err != ErrSlotNotFound {
+		t.Errorf("Expected ErrSlotNotFound, got %v", err)
+		return
+	}
+	if err := DiscussionSetPossibleSlots("nonexistent", slots[:1]); err != ErrDiscussionNotFound {
+		t.Errorf("Expected ErrDiscussionNotFound, got %v", err)
+		return
+	}
+	if err := DiscussionSetPossibleSlots(discussions[0].DiscussionID,
+		[]SlotID{slots[0], slots[0]}); err != nil {
+		t.Errorf("Listing a slot twice: %v", err)
+		return
+	}
+
+	t.Logf("Checking that an edit is made in full or not at all")
+	edited := discussions[0]
+	edited.Title = "Edited " + edited.Title
+	if err := DiscussionEdit(&edited, []string{"edited"},
+		[]SlotID{"nonexistent"}); err != ErrSlotNotFound {
+		t.Errorf("Expected ErrSlotNotFound, got %v", err)
+		return
+	}
+	if disc, err := DiscussionFindById(edited.DiscussionID); err != nil || disc == nil ||
+		disc.Title != discussions[0].Title {
+		t.Errorf("Expected title %s after failed edit, got %v (error %v)",
+			discussions[0].Title, disc, err)
+		return
+	}
+	if tags, err := DiscussionGetTags(edited.DiscussionID); err != nil || len(tags) != 0 {
+		t.Errorf("Expected no tags after failed edit, got %v (error %v)", tags, err)
+		return
+	}
+	if err := DiscussionEdit(&edited, nil, slots[:1]); err != nil {
+		t.Errorf("DiscussionEdit: %v", err)
+		return
+	}
+	for _, ds := range TimetableGetPossibleSlots(edited.DiscussionID) {
+		if ds.Checked != (ds.SlotID == slots[0]) {
+			t.Errorf("Slot %s: expected possible %v, got %v", ds.Label, ds.SlotID == slots[0], ds.Checked)
+			return
+		}
+	}
+	if err := DiscussionSetPublic(edited.DiscussionID, true); err != nil {
+		t.Errorf("DiscussionSetPublic: %v", err)
+		return
+	}
+
+	// Setting every slot should leave no restriction recorded
+	if err := DiscussionSetPossibleSlots(discussions[0].DiscussionID, slots); err != nil {
+		t.Errorf("DiscussionSetPossibleSlots: %v", err)
+		return
+	}
+	var count int
+	if err := event.Get(&count, `select count(*) from event_discussions_possible_slots`); err != nil {
+		t.Errorf("Counting possible slots: %v", err)
+		return
+	}
+	if count != 0 {
+		t.Errorf("Expected no possible slots rows, got %d", count)
+		return
+	}
+
+	// Restrict every discussion to a single slot or two
+	possible := make(map[DiscussionID]map[SlotID]bool)
+	for i := range discussions {
+		allowed := []SlotID{slots[i%len(slots)]}
+		if i%2 == 0 {
+			allowed = append(allowed, slots[(i+1)%len(slots)])
+		}
+		if err := DiscussionSetPossibleSlots(discussions[i].DiscussionID, allowed); err != nil {
+			t.Errorf("DiscussionSetPossibleSlots: %v", err)
+			return
+		}
+		possible[discussions[i].DiscussionID] = make(map[SlotID]bool)
+		for _, sid := range allowed {
+			possible[discussions[i].DiscussionID][sid] = true
+		}
+	}
+
+	for _, ds := range TimetableGetPossibleSlots(discussions[1].DiscussionID) {
+		if ds.Checked != (ds.SlotID == slots[1]) {
+			t.Errorf("Slot %s: expected possible %v, got %v", ds.Label, ds.SlotID == slots[1], ds.Checked)
+			return
+		}
+	}
+
+	for _, algo := range []SearchAlgo{SearchHeuristicOnly, SearchRandom, SearchGenetic, SearchAnnealing, SearchExact} {
+		t.Logf("Checking possible slots for %s", algo)
+		if err := MakeSchedule(SearchOptions{Algo: algo, Validate: true,
+			MaxIterations: testSearchIterations}); err != nil {
+			t.Errorf("MakeSchedule(%s): %v", algo, err)
+			return
+		}
+
+		_, entries, err := loadSchedule()
+		if err != nil {
+			t.Errorf("loadSchedule: %v", err)
+			return
+		}
+		for _, e := range entries {
+			if !possible[e.DiscussionID][e.SlotID] {
+				t.Errorf("%s: discussion %v scheduled in slot %v, which isn't possible",
+					algo, e.DiscussionID, e.SlotID)
+				return
+			}
+		}
+
+		violations, _, err := ValidateSchedule()
+		if err != nil {
+			t.Errorf("ValidateSchedule: %v", err)
+			return
+		}
+		if len(violations) != 0 {
+			t.Errorf("%s: unexpected violations %v", algo, violations)
+			return
+		}
+	}
+
+	return false
+}
+
+func testUnitScheduleAvailability(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 2 days * 2 slots * 2 locations
+	if testSetupTimetable(t, 2, 2, 2) {
+		return
+	}
+
+	users, discussions, subexit := testSetupEvent(t, 10, 6)
+	if subexit {
+		return
+	}
+
+	days, err := DayGetAll()
+	if err != nil {
+		t.Errorf("DayGetAll: %v", err)
+		return
+	}
+	var slots []SlotID
+	for _, ds := range TimetableGetUnavailableSlots(users[0].UserID) {
+		if ds.Checked {
+			t.Errorf("Slot %s unavailable before anything was set", ds.Label)
+			return
+		}
+		slots = append(slots, ds.SlotID)
+	}
+	if len(slots) != 4 {
+		t.Errorf("Expected 4 slots, got %d", len(slots))
+		return
+	}
+
+	if err := UserSetUnavailable("nonexistent", nil, nil); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+		return
+	}
+	if err := UserSetUnavailable(users[0].UserID, []SlotID{"nonexistent"}, nil); err != ErrSlotNotFound {
+		t.Errorf("Expected ErrSlotNotFound, got %v", err)
+		return
+	}
+	if err := UserSetUnavailable(users[0].UserID, nil, []DayID{100}); err != ErrDayNotFound {
+		t.Errorf("Expected ErrDayNotFound, got %v", err)
+		return
+	}
+
+	// The owner of the first discussion is only around for the last
+	// slot
+	owner := discussions[0].Owner
+	if err := UserSetUnavailable(owner, slots[2:3], []DayID{days[0].DayID}); err != nil {
+		t.Errorf("UserSetUnavailable: %v", err)
+		return
+	}
+	for i, ds := range TimetableGetUnavailableSlots(owner) {
+		if ds.Checked != (i < 3) {
+			t.Errorf("Slot %d (%s): expected unavailable %v, got %v", i, ds.Label, i < 3, ds.Checked)
+			return
+		}
+	}
+	if dd := TimetableGetUnavailableDays(owner); !dd[0].Checked || dd[1].Checked {
+		t.Errorf("Unexpected unavailable days %v", dd)
+		return
+	}
+
+	// Someone else isn't there at all
+	var absent UserID
+	for i := range users {
+		if users[i].UserID != owner {
+			absent = users[i].UserID
+			break
+		}
+	}
+	if err := UserSetUnavailable(absent, nil, []DayID{days[0].DayID, days[1].DayID}); err != nil {
+		t.Errorf("UserSetUnavailable: %v", err)
+		return
+	}
+
+	for _, algo := range []SearchAlgo{SearchHeuristicOnly, SearchRandom, SearchGenetic, SearchAnnealing, SearchExact} {
+		t.Logf("Checking availability for %s", algo)
+		if err := MakeSchedule(SearchOptions{Algo: algo, Validate: true,
+			MaxIterations: testSearchIterations}); err != nil {
+			t.Errorf("MakeSchedule(%s): %v", algo, err)
+			return
+		}
+
+		_, entries, err := loadSchedule()
+		if err != nil {
+			t.Errorf("loadSchedule: %v", err)
+			return
+		}
+		for _, e := range entries {
+			if e.DiscussionID == discussions[0].DiscussionID && e.SlotID != slots[3] {
+				t.Errorf("%s: discussion %v scheduled in slot %v, when its owner is unavailable",
+					algo, e.DiscussionID, e.SlotID)
+				return
+			}
+		}
+
+		violations, _, err := ValidateSchedule()
+		if err != nil {
+			t.Errorf("ValidateSchedule: %v", err)
+			return
+		}
+		if len(violations) != 0 {
+			t.Errorf("%s: unexpected violations %v", algo, violations)
+			return
+		}
+
+		report, err := UserReportGet(absent)
+		if err != nil {
+			t.Errorf("UserReportGet: %v", err)
+			return
+		}
+		if report.Utility != 0 {
+			t.Errorf("%s: absent user has utility %d", algo, report.Utility)
+			return
+		}
+		for _, rd := range report.Discussions {
+			if rd.Time != "" && !rd.IsUnavailable {
+				t.Errorf("%s: absent user's discussion %v not marked unavailable", algo, rd.DiscussionID)
+				return
+			}
+		}
+	}
+
+	// Clearing makes everyone available again
+	if err := UserSetUnavailable(owner, nil, nil); err != nil {
+		t.Errorf("UserSetUnavailable: %v", err)
+		return
+	}
+	for _, ds := range TimetableGetUnavailableSlots(owner) {
+		if ds.Checked {
+			t.Errorf("Slot %s still unavailable after clearing", ds.Label)
+			return
+		}
+	}
+
+	return false
+}
+
+func testUnitScheduleTracks(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 2 days * 2 slots * 3 locations
+	if testSetupTimetable(t, 2, 2, 3) {
+		return
+	}
+
+	_, discussions, subexit := testSetupEvent(t, 10, 6)
+	if subexit {
+		return
+	}
+
+	t.Logf("Testing tag parsing")
+	tags, err := TagsParse("Security, ARM  toolstack,security,")
+	if err != nil {
+		t.Errorf("TagsParse: %v", err)
+		return
+	}
+	if strings.Join(tags, " ") != "arm security toolstack" {
+		t.Errorf("Unexpected tags %v", tags)
+		return
+	}
+	if _, err := TagsParse("bad/tag"); err != errInvalidTag {
+		t.Errorf("Expected errInvalidTag, got %v", err)
+		return
+	}
+	if _, err := TagsParse("a b c d e f"); err != errTooManyTags {
+		t.Errorf("Expected errTooManyTags, got %v", err)
+		return
+	}
+	if err := DiscussionSetTags("nonexistent", tags); err != ErrDiscussionNotFound {
+		t.Errorf("Expected ErrDiscussionNotFound, got %v", err)
+		return
+	}
+
+	// The first four discussions are in the same track
+	track := make(map[DiscussionID]bool)
+	for i := 0; i < 4; i++ {
+		if err := DiscussionSetTags(discussions[i].DiscussionID, []string{"xen", "Toolstack"}); err != nil {
+			t.Errorf("DiscussionSetTags: %v", err)
+			return
+		}
+		track[discussions[i].DiscussionID] = true
+	}
+	if tags, err := DiscussionGetTags(discussions[0].DiscussionID); err != nil ||
+		strings.Join(tags, " ") != "toolstack xen" {
+		t.Errorf("DiscussionGetTags: unexpected tags %v, error %v", tags, err)
+		return
+	}
+	count := 0
+	if err := DiscussionIterateTag("xen", func(d *Discussion) error {
+		if !track[d.DiscussionID] {
+			t.Errorf("Discussion %v unexpectedly tagged", d.DiscussionID)
+		}
+		count++
+		return nil
+	}); err != nil || count != 4 {
+		t.Errorf("DiscussionIterateTag: found %d discussions, error %v", count, err)
+		return
+	}
+
+	tracks, err := TrackGetAll()
+	if err != nil {
+		t.Errorf("TrackGetAll: %v", err)
+		return
+	}
+	if len(tracks) != 2 || tracks[1].Tag != "xen" || tracks[1] != (Track{Tag: "xen", AvoidOverlap: true}) {
+		t.Errorf("Unexpected tracks %v", tracks)
+		return
+	}
+
+	// Toolstack discussions may overlap; xen discussions shouldn't,
+	// and should be kept in one room
+	if err := TracksSet([]Track{
+		{Tag: "toolstack"},
+		{Tag: "xen", AvoidOverlap: true, SameRoom: true}}); err != nil {
+		t.Errorf("TracksSet: %v", err)
+		return
+	}
+
+	for _, algo := range []SearchAlgo{SearchHeuristicOnly, SearchRandom, SearchGenetic, SearchAnnealing, SearchExact} {
+		t.Logf("Checking tracks for %s", algo)
+		if err := MakeSchedule(SearchOptions{Algo: algo, Validate: true,
+			TrackWeight:   10 * InterestMax,
+			MaxIterations: testSearchIterations}); err != nil {
+			t.Errorf("MakeSchedule(%s): %v", algo, err)
+			return
+		}
+
+		_, entries, err := loadSchedule()
+		if err != nil {
+			t.Errorf("loadSchedule: %v", err)
+			return
+		}
+		slots := make(map[SlotID]bool)
+		room := LocationID(0)
+		for _, e := range entries {
+			if !track[e.DiscussionID] {
+				continue
+			}
+			if slots[e.SlotID] {
+				t.Errorf("%s: two discussions from the same track in slot %v", algo, e.SlotID)
+				return
+			}
+			slots[e.SlotID] = true
+			if room != 0 && e.LocationID != room {
+				t.Errorf("%s: track in locations %v and %v", algo, room, e.LocationID)
+				return
+			}
+			room = e.LocationID
+		}
+	}
+
+	// With everything in the track, overlaps can't be avoided, and
+	// are reported as warnings
+	for i := 4; i < len(discussions); i++ {
+		if err := DiscussionSetTags(discussions[i].DiscussionID, []string{"xen"}); err != nil {
+			t.Errorf("DiscussionSetTags: %v", err)
+			return
+		}
+	}
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly, Validate: true}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	violations, warnings, err := ValidateSchedule()
+	if err != nil {
+		t.Errorf("ValidateSchedule: %v", err)
+		return
+	}
+	if len(violations) != 0 {
+		t.Errorf("Unexpected violations %v", violations)
+		return
+	}
+	found := false
+	for _, w := range warnings {
+		if strings.Contains(w, "track xen") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected track overlap warnings, got %v", warnings)
+		return
+	}
+
+	return false
+}
+
+func testUnitScheduleObjective(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 1 day * 2 slots * 1 location: only two of three discussions
+	// can be scheduled
+	if testSetupTimetable(t, 1, 2, 1) {
+		return
+	}
+
+	owner, subexit := testNewUser(t)
+	if subexit {
+		return
+	}
+	var discussions [3]Discussion
+	for i := range discussions {
+		discussions[i], subexit = testNewDiscussion(t, owner.UserID)
+		if subexit {
+			return
+		}
+		if err := DiscussionSetPublic(discussions[i].DiscussionID, true); err != nil {
+			t.Errorf("DiscussionSetPublic: %v", err)
+			return
+		}
+	}
+
+	// Two heavy raters want the first two discussions; someone else
+	// only mildly wants the third.  The most total utility comes from
+	// scheduling the first two, but then the third user gets nothing.
+	interest := [][3]int{{100, 100, 0}, {100, 100, 0}, {0, 0, 10}}
+	for _, ui := range interest {
+		user, subexit := testNewUser(t)
+		if subexit {
+			return
+		}
+		for d, i := range ui {
+			if i == 0 {
+				continue
+			}
+			if err := user.SetInterest(&discussions[d], i); err != nil {
+				t.Errorf("SetInterest: %v", err)
+				return
+			}
+		}
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly, Objective: "bogus"}); err == nil {
+		t.Errorf("Expected error for unknown objective")
+		return
+	}
+
+	for _, tc := range []struct {
+		algo       SearchAlgo
+		opt        SearchOptions
+		scheduled  bool // Whether the third discussion is scheduled
+		minPercent int
+	}{
+		{SearchHeuristicOnly, SearchOptions{}, false, 0},
+		{SearchExact, SearchOptions{Objective: ObjectiveTotal}, false, 0},
+		{SearchExact, SearchOptions{Objective: ObjectiveMaxMin}, true, 50},
+		{SearchExact, SearchOptions{Objective: ObjectiveBlend}, true, 50},
+		{SearchExact, SearchOptions{Objective: ObjectiveBlend, FairnessWeight: 1}, false, 0},
+		{SearchAnnealing, SearchOptions{Objective: ObjectiveMaxMin}, true, 50},
+	} {
+		o := tc.opt
+		o.Algo = tc.algo
+		o.Validate = true
+		o.MaxIterations = testSearchIterations
+		t.Logf("Checking %s with objective %q, fairness weight %d", o.Algo, o.Objective, o.FairnessWeight)
+		if err := MakeSchedule(o); err != nil {
+			t.Errorf("MakeSchedule: %v", err)
+			return
+		}
+
+		_, entries, err := loadSchedule()
+		if err != nil {
+			t.Errorf("loadSchedule: %v", err)
+			return
+		}
+		scheduled := false
+		for _, e := range entries {
+			if e.DiscussionID == discussions[2].DiscussionID {
+				scheduled = true
+			}
+		}
+		if scheduled != tc.scheduled {
+			t.Errorf("Expected third discussion scheduled %v, got %v", tc.scheduled, scheduled)
+			return
+		}
+
+		versions, err := ScheduleVersionGetAll()
+		if err != nil {
+			t.Errorf("ScheduleVersionGetAll: %v", err)
+			return
+		}
+		if versions[0].MinPercent != tc.minPercent {
+			t.Errorf("Expected worst-off user to get %d%%, got %d%%",
+				tc.minPercent, versions[0].MinPercent)
+			return
+		}
+		if o.Objective == "" {
+			o.Objective = ObjectiveTotal
+		}
+		if !strings.HasPrefix(versions[0].Objective, string(o.Objective)) {
+			t.Errorf("Expected objective %s recorded, got %s", o.Objective, versions[0].Objective)
+			return
+		}
+		if dist, err := UtilityDistributionGet(); err != nil || dist.MinPercent != tc.minPercent {
+			t.Errorf("UtilityDistributionGet: expected minimum %d%%, got %v (error %v)",
+				tc.minPercent, dist, err)
+			return
+		}
+	}
+
+	return false
+}
+
+func testUnitScheduleNormalize(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 1 day * 2 slots * 1 location: only two of three discussions
+	// can be scheduled
+	if testSetupTimetable(t, 1, 2, 1) {
+		return
+	}
+
+	owner, subexit := testNewUser(t)
+	if subexit {
+		return
+	}
+	var discussions [3]Discussion
+	for i := range discussions {
+		discussions[i], subexit = testNewDiscussion(t, owner.UserID)
+		if subexit {
+			return
+		}
+		if err := DiscussionSetPublic(discussions[i].DiscussionID, true); err != nil {
+			t.Errorf("DiscussionSetPublic: %v", err)
+			return
+		}
+	}
+
+	// The owner is interested in everything.  One user is very
+	// interested in the first two discussions; another is only mildly
+	// interested in the third, but it's the only one they want.  Raw
+	// interest favours the first user; normalized, the second user's
+	// one discussion is worth as much as both of the first user's.
+	interest := [][3]int{{100, 100, 0}, {0, 0, 10}}
+	var users []User
+	for _, ui := range interest {
+		user, subexit := testNewUser(t)
+		if subexit {
+			return
+		}
+		users = append(users, user)
+		for d, i := range ui {
+			if i == 0 {
+				continue
+			}
+			if err := user.SetInterest(&discussions[d], i); err != nil {
+				t.Errorf("SetInterest: %v", err)
+				return
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		normalize bool
+		scheduled bool // Whether the third discussion is scheduled
+		score     int
+	}{
+		{false, false, 400}, // owner 200 + 200
+		{true, true, 310},   // owner 200 + 100 + 10
+	} {
+		t.Logf("Checking exact search, normalize %v", tc.normalize)
+		if err := MakeSchedule(SearchOptions{Algo: SearchExact, Normalize: tc.normalize, Validate: true}); err != nil {
+			t.Errorf("MakeSchedule: %v", err)
+			return
+		}
+
+		_, entries, err := loadSchedule()
+		if err != nil {
+			t.Errorf("loadSchedule: %v", err)
+			return
+		}
+		scheduled := false
+		for _, e := range entries {
+			if e.DiscussionID == discussions[2].DiscussionID {
+				scheduled = true
+			}
+		}
+		if scheduled != tc.scheduled {
+			t.Errorf("Expected third discussion scheduled %v, got %v", tc.scheduled, scheduled)
+			return
+		}
+
+		// The version history records raw utility either way
+		versions, err := ScheduleVersionGetAll()
+		if err != nil {
+			t.Errorf("ScheduleVersionGetAll: %v", err)
+			return
+		}
+		if versions[0].Score != tc.score {
+			t.Errorf("Expected score %d recorded, got %d", tc.score, versions[0].Score)
+			return
+		}
+	}
+
+	// Each user's normalized interest adds up to the budget
+	for i, user := range users {
+		r, err := UserReportGet(user.UserID)
+		if err != nil {
+			t.Errorf("UserReportGet: %v", err)
+			return
+		}
+		if r.NormalizedMaxScore != InterestBudget {
+			t.Errorf("User %d: expected normalized max score %d, got %d",
+				i, InterestBudget, r.NormalizedMaxScore)
+			return
+		}
+		if r.MaxScore != interest[i][0]+interest[i][1]+interest[i][2] {
+			t.Errorf("User %d: unexpected raw max score %d", i, r.MaxScore)
+			return
+		}
+	}
+
+	dist, err := UtilityDistributionGet()
+	if err != nil {
+		t.Errorf("UtilityDistributionGet: %v", err)
+		return
+	}
+	// Owner 333 + 333, 500, 1000
+	if dist.Utility != 310 || dist.NormalizedUtility != 2166 {
+		t.Errorf("Expected utility 310 raw, 2166 normalized; got %d and %d",
+			dist.Utility, dist.NormalizedUtility)
+		return
+	}
+
+	return false
+}
+
+func testUnitScheduleLength(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 2 days * 4 slots * 2 locations.  There's a break before the
+	// third slot each day, so a discussion taking two slots can start
+	// in the first or third, and one taking three slots can't fit
+	// anywhere.
+	if testSetupTimetable(t, 2, 4, 2) {
+		return
+	}
+
+	_, discussions, subexit := testSetupEvent(t, 10, 6)
+	if subexit {
+		return
+	}
+
+	setLength := func(disc *Discussion, length int) error {
+		disc.Length = length
+		if err := DiscussionUpdate(disc); err != nil {
+			return err
+		}
+		return DiscussionSetPublic(disc.DiscussionID, true)
+	}
+
+	t.Logf("Setting invalid lengths")
+	for _, length := range []int{-1, MaxDiscussionLength + 1} {
+		if err := setLength(&discussions[0], length); err != errInvalidLength {
+			t.Errorf("Length %d: expected errInvalidLength, got %v", length, err)
+			return
+		}
+	}
+
+	double, triple := discussions[0].DiscussionID, discussions[1].DiscussionID
+	if err := setLength(&discussions[0], 2); err != nil {
+		t.Errorf("Setting length: %v", err)
+		return
+	}
+	if err := setLength(&discussions[1], 3); err != nil {
+		t.Errorf("Setting length: %v", err)
+		return
+	}
+	if disc, err := DiscussionFindById(double); err != nil || disc.Length != 2 {
+		t.Errorf("Expected length 2, got %v (error %v)", disc, err)
+		return
+	}
+
+	// checkTimetable checks that the double-length discussion takes
+	// two consecutive slots in the same location, and the triple-length
+	// one isn't scheduled
+	checkTimetable := func() bool {
+		violations, _, err := ValidateSchedule()
+		if err != nil || len(violations) > 0 {
+			t.Errorf("ValidateSchedule: %v (error %v)", violations, err)
+			return true
+		}
+
+		tt := GetTimetable()
+		var found []TimetableDiscussion
+		for _, day := range tt.Days {
+			for i, slot := range day.Slots {
+				for _, disc := range slot.Discussions {
+					switch disc.DiscussionID {
+					case triple:
+						t.Errorf("Discussion taking three slots scheduled at %s", slot.Time)
+						return true
+					case double:
+						if disc.IsContinuation {
+							if len(found) != 1 || day.Slots[i-1].IsBreak {
+								t.Errorf("Unexpected continuation at %s", slot.Time)
+								return true
+							}
+						}
+						found = append(found, disc)
+					}
+				}
+			}
+		}
+		if len(found) != 2 || found[0].IsContinuation || !found[1].IsContinuation {
+			t.Errorf("Expected discussion in two slots, got %v", found)
+			return true
+		}
+		if found[0].LocationInfo.LocationID != found[1].LocationInfo.LocationID {
+			t.Errorf("Discussion changed location from %v to %v",
+				found[0].LocationInfo.LocationName, found[1].LocationInfo.LocationName)
+			return true
+		}
+		if found[1].StartTime != found[0].StartTime || found[0].Length != 2 {
+			t.Errorf("Unexpected start time or length: %v", found)
+			return true
+		}
+		return false
+	}
+
+	for _, algo := range []SearchAlgo{SearchHeuristicOnly, SearchRandom, SearchGenetic,
+		SearchAnnealing, SearchExact} {
+		t.Logf("Checking %s", algo)
+		opt := SearchOptions{Algo: algo, Validate: true, MaxIterations: testSearchIterations}
+		if err := MakeSchedule(opt); err != nil {
+			t.Errorf("MakeSchedule: %v", err)
+			return
+		}
+		if checkTimetable() {
+			return
+		}
+	}
+
+	t.Logf("Rolling back")
+	versions, err := ScheduleVersionGetAll()
+	if err != nil {
+		t.Errorf("ScheduleVersionGetAll: %v", err)
+		return
+	}
+	if err := ScheduleRollback(versions[len(versions)-1].Version); err != nil {
+		t.Errorf("ScheduleRollback: %v", err)
+		return
+	}
+	if checkTimetable() {
+		return
+	}
+
+	t.Logf("Changing length unschedules the discussion")
+	if err := setLength(&discussions[0], 1); err != nil {
+		t.Errorf("Setting length: %v", err)
+		return
+	}
+	if _, when := discussions[0].Slot(); when != "" {
+		t.Errorf("Expected discussion to be unscheduled, but it's at %s", when)
+		return
+	}
+	if violations, _, err := ValidateSchedule(); err != nil || len(violations) > 0 {
+		t.Errorf("ValidateSchedule: %v (error %v)", violations, err)
+		return
+	}
+
+	return false
+}
+
+func testUnitSchedulePin(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 2 days * 4 slots * 2 locations, with a break before the third
+	// slot each day, and a third location which isn't a place
+	if testSetupTimetable(t, 2, 4, 2) {
+		return
+	}
+	hall := Location{LocationName: "Hall"}
+	if err := NewLocation(&hall); err != nil {
+		t.Errorf("NewLocation: %v", err)
+		return
+	}
+
+	slots, err := SlotGetAll()
+	if err != nil {
+		t.Errorf("SlotGetAll: %v", err)
+		return
+	}
+	if len(slots) != 10 || !slots[2].IsBreak {
+		t.Errorf("Unexpected slots %v", slots)
+		return
+	}
+
+	users, discussions, subexit := testSetupEvent(t, 10, 6)
+	if subexit {
+		return
+	}
+
+	// The third discussion has the same owner as the first
+	for i := range discussions {
+		if i == 0 {
+			discussions[i].Length = 2
+		}
+		if i == 2 {
+			discussions[i].Owner = users[0].UserID
+		}
+		if err := DiscussionUpdate(&discussions[i]); err != nil {
+			t.Errorf("DiscussionUpdate: %v", err)
+			return
+		}
+		if err := DiscussionSetPublic(discussions[i].DiscussionID, true); err != nil {
+			t.Errorf("DiscussionSetPublic: %v", err)
+			return
+		}
+	}
+	double := &discussions[0]
+	single := &discussions[1]
+
+	t.Logf("Setting invalid pins")
+	if err := LockedSlotsSet([]SlotID{slots[9].SlotID}, nil); err != nil {
+		t.Errorf("LockedSlotsSet: %v", err)
+		return
+	}
+	for _, tcase := range []struct {
+		did  DiscussionID
+		slot SlotID
+		loc  LocationID
+		err  error
+	}{
+		{"nonexistent", slots[0].SlotID, 1, ErrDiscussionNotFound},
+		{single.DiscussionID, slots[0].SlotID, 99, ErrLocationNotFound},
+		{single.DiscussionID, slots[0].SlotID, hall.LocationID, errPinNotPlace},
+		{single.DiscussionID, "nonexistent", 1, ErrSlotNotFound},
+		{single.DiscussionID, slots[2].SlotID, 1, errPinDoesntFit},
+		{double.DiscussionID, slots[1].SlotID, 1, errPinDoesntFit},
+		{double.DiscussionID, slots[4].SlotID, 1, errPinDoesntFit},
+		{single.DiscussionID, slots[9].SlotID, 1, errPinLocked},
+		{double.DiscussionID, slots[8].SlotID, 1, errPinLocked},
+	} {
+		if err := DiscussionSetPin(tcase.did, tcase.slot, tcase.loc); err != tcase.err {
+			t.Errorf("Pinning %v to %v/%v: expected %v, got %v",
+				tcase.did, tcase.slot, tcase.loc, tcase.err, err)
+			return
+		}
+	}
+	if err := LockedSlotsSet(nil, nil); err != nil {
+		t.Errorf("LockedSlotsSet: %v", err)
+		return
+	}
+
+	if err := DiscussionSetPin(double.DiscussionID, slots[3].SlotID, 2); err != nil {
+		t.Errorf("DiscussionSetPin: %v", err)
+		return
+	}
+	if err := DiscussionSetPin(single.DiscussionID, slots[4].SlotID, 2); err != errPinConflict {
+		t.Errorf("Pinning over a pinned discussion: expected errPinConflict, got %v", err)
+		return
+	}
+	if err := DiscussionSetPin(discussions[2].DiscussionID, slots[4].SlotID, 1); err != errPinConflict {
+		t.Errorf("Pinning an owner twice: expected errPinConflict, got %v", err)
+		return
+	}
+	if err := DiscussionSetPin(single.DiscussionID, slots[5].SlotID, 1); err != nil {
+		t.Errorf("DiscussionSetPin: %v", err)
+		return
+	}
+
+	if pin, err := DiscussionGetPin(double.DiscussionID); err != nil || pin == nil ||
+		pin.SlotID != slots[3].SlotID || pin.LocationID != 2 {
+		t.Errorf("DiscussionGetPin: expected slot %v location 2, got %v (error %v)",
+			slots[3].SlotID, pin, err)
+		return
+	}
+	for _, ds := range TimetableGetPinSlots(single.DiscussionID) {
+		if ds.Checked != (ds.SlotID == slots[5].SlotID) {
+			t.Errorf("Slot %s: expected pinned %v, got %v", ds.Label, ds.SlotID == slots[5].SlotID, ds.Checked)
+			return
+		}
+	}
+
+	// checkPinned checks that the discussion takes the slots starting
+	// where it's pinned, in the location it's pinned to
+	checkPinned := func(did DiscussionID, want []SlotID, loc LocationID) bool {
+		var entries []struct {
+			SlotID     SlotID
+			LocationID LocationID
+		}
+		if err := event.Select(&entries, `
+            select slotid, locationid
+                from event_schedule natural join event_slots
+                where discussionid = ?
+                order by dayid, slotidx`, did); err != nil {
+			t.Errorf("Getting schedule: %v", err)
+			return true
+		}
+		if len(entries) != len(want) {
+			t.Errorf("Discussion %v: expected %d slots, got %v", did, len(want), entries)
+			return true
+		}
+		for i := range entries {
+			if entries[i].SlotID != want[i] || entries[i].LocationID != loc {
+				t.Errorf("Discussion %v: expected %v in location %v, got %v", did, want, loc, entries)
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, algo := range []SearchAlgo{SearchHeuristicOnly, SearchRandom, SearchGenetic,
+		SearchAnnealing, SearchExact} {
+		t.Logf("Checking %s", algo)
+		opt := SearchOptions{Algo: algo, Validate: true, MaxIterations: testSearchIterations}
+		if err := MakeSchedule(opt); err != nil {
+			t.Errorf("MakeSchedule: %v", err)
+			return
+		}
+		if checkPinned(double.DiscussionID, []SlotID{slots[3].SlotID, slots[4].SlotID}, 2) ||
+			checkPinned(single.DiscussionID, []SlotID{slots[5].SlotID}, 1) {
+			return
+		}
+	}
+
+	t.Logf("Changing length unpins the discussion")
+	double.Length = 1
+	if err := DiscussionUpdate(double); err != nil {
+		t.Errorf("DiscussionUpdate: %v", err)
+		return
+	}
+	if pin, err := DiscussionGetPin(double.DiscussionID); err != nil || pin != nil {
+		t.Errorf("Expected discussion to be unpinned, got %v (error %v)", pin, err)
+		return
+	}
+
+	t.Logf("Checking pins against other constraints")
+	other := &discussions[3]
+	if err := UserSetUnavailable(users[3].UserID, []SlotID{slots[0].SlotID}, nil); err != nil {
+		t.Errorf("UserSetUnavailable: %v", err)
+		return
+	}
+	if err := DiscussionSetPin(other.DiscussionID, slots[0].SlotID, 1); err != errPinUnavailable {
+		t.Errorf("Pinning where the owner is away: expected errPinUnavailable, got %v", err)
+		return
+	}
+	if err := UserSetUnavailable(users[3].UserID, nil, nil); err != nil {
+		t.Errorf("UserSetUnavailable: %v", err)
+		return
+	}
+	if err := DiscussionSetPossibleSlots(other.DiscussionID, []SlotID{slots[1].SlotID}); err != nil {
+		t.Errorf("DiscussionSetPossibleSlots: %v", err)
+		return
+	}
+	if err := DiscussionSetPin(other.DiscussionID, slots[0].SlotID, 1); err != errPinNotPossible {
+		t.Errorf("Pinning outside possible slots: expected errPinNotPossible, got %v", err)
+		return
+	}
+	var all []SlotID
+	for _, slot := range slots {
+		if !slot.IsBreak {
+			all = append(all, slot.SlotID)
+		}
+	}
+	if err := DiscussionSetPossibleSlots(other.DiscussionID, all); err != nil {
+		t.Errorf("DiscussionSetPossibleSlots: %v", err)
+		return
+	}
+	if err := DiscussionOrderAdd(single.DiscussionID, other.DiscussionID, false); err != nil {
+		t.Errorf("DiscussionOrderAdd: %v", err)
+		return
+	}
+	if err := DiscussionSetPin(other.DiscussionID, slots[0].SlotID, 1); err != errPinOrder {
+		t.Errorf("Pinning out of order: expected errPinOrder, got %v", err)
+		return
+	}
+	if err := DiscussionSetPin(other.DiscussionID, slots[6].SlotID, 2); err != nil {
+		t.Errorf("DiscussionSetPin: %v", err)
+		return
+	}
+	// Until the schedule is remade, the stored schedule has the
+	// discussion wherever it was before it was pinned
+	stored, entries, err := loadSchedule()
+	if err != nil {
+		t.Errorf("loadSchedule: %v", err)
+		return
+	}
+	want := -1
+	for _, e := range entries {
+		if e.DiscussionID == other.DiscussionID {
+			want = stored.data.slotIndex()[e.SlotID]
+			break
+		}
+	}
+	if got := stored.slotOf[stored.data.discussionIndex()[other.DiscussionID]]; got != want {
+		t.Errorf("Stored schedule: expected newly pinned discussion in slot %d, got %d", want, got)
+		return
+	}
+	if err := DiscussionOrderRemove(single.DiscussionID, other.DiscussionID); err != nil {
+		t.Errorf("DiscussionOrderRemove: %v", err)
+		return
+	}
+	if err := DiscussionClearPin(other.DiscussionID); err != nil {
+		t.Errorf("DiscussionClearPin: %v", err)
+		return
+	}
+
+	t.Logf("Locking slots with pins")
+	// The stored schedule has the pinned discussion where it's pinned
+	if err := LockedSlotsSet([]SlotID{slots[5].SlotID}, nil); err != nil {
+		t.Errorf("LockedSlotsSet: %v", err)
+		return
+	}
+	if err := LockedSlotsSet(nil, nil); err != nil {
+		t.Errorf("LockedSlotsSet: %v", err)
+		return
+	}
+	// Pinning a discussion where another is stored, then locking the
+	// slot, would lock the other in the way of the pin
+	var entry DiscussionPin
+	if err := event.Get(&entry, `
+        select discussionid, slotid, locationid from event_schedule
+            where discussionid not in (?, ?)
+            limit 1`, single.DiscussionID, other.DiscussionID); err != nil {
+		t.Errorf("Getting schedule: %v", err)
+		return
+	}
+	if err := DiscussionSetPin(other.DiscussionID, entry.SlotID, entry.LocationID); err != nil {
+		t.Errorf("DiscussionSetPin: %v", err)
+		return
+	}
+	if err := LockedSlotsSet([]SlotID{entry.SlotID}, nil); err != errLockPinned {
+		t.Errorf("Locking a slot pinned to elsewhere: expected errLockPinned, got %v", err)
+		return
+	}
+	if err := DiscussionClearPin(other.DiscussionID); err != nil {
+		t.Errorf("DiscussionClearPin: %v", err)
+		return
+	}
+
+	t.Logf("Unpinning")
+	if err := DiscussionClearPin(single.DiscussionID); err != nil {
+		t.Errorf("DiscussionClearPin: %v", err)
+		return
+	}
+	if pin, err := DiscussionGetPin(single.DiscussionID); err != nil || pin != nil {
+		t.Errorf("Expected discussion to be unpinned, got %v (error %v)", pin, err)
+		return
+	}
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly, Validate: true}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+
+	return false
+}
+
+// testUnitSchedulePinPlacement checks that a discussion taking several
+// slots isn't given a location which a pinned discussion takes in one
+// of its later slots.
+func testUnitSchedulePinPlacement(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 1 day * 2 slots * 2 locations
+	if testSetupTimetable(t, 1, 2, 2) {
+		return
+	}
+	slots, err := SlotGetAll()
+	if err != nil || len(slots) != 2 {
+		t.Errorf("Expected 2 slots, got %v (error %v)", slots, err)
+		return
+	}
+
+	users, discussions, subexit := testSetupEvent(t, 10, 3)
+	if subexit {
+		return
+	}
+	for i := range discussions {
+		if i == 0 {
+			discussions[i].Length = 2
+		}
+		if err := DiscussionUpdate(&discussions[i]); err != nil {
+			t.Errorf("DiscussionUpdate: %v", err)
+			return
+		}
+		if err := DiscussionSetPublic(discussions[i].DiscussionID, true); err != nil {
+			t.Errorf("DiscussionSetPublic: %v", err)
+			return
+		}
+	}
+	// Make the long discussion the most popular, so that it would
+	// get the largest location if only its first slot counted
+	for i := range users {
+		if i == 0 {
+			continue
+		}
+		if err := users[i].SetInterest(&discussions[0], InterestMax); err != nil {
+			t.Errorf("SetInterest: %v", err)
+			return
+		}
+	}
+	if err := DiscussionSetPin(discussions[2].DiscussionID, slots[1].SlotID, 2); err != nil {
+		t.Errorf("DiscussionSetPin: %v", err)
+		return
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly, Validate: true}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	var count int
+	if err := event.Get(&count, `select count(*) from event_schedule`); err != nil || count != 4 {
+		t.Errorf("Expected 4 schedule entries, got %d (error %v)", count, err)
+		return
+	}
+	if violations, _, err := ValidateSchedule(); err != nil || len(violations) > 0 {
+		t.Errorf("ValidateSchedule: %v (error %v)", violations, err)
+		return
+	}
+	var loc LocationID
+	if err := event.Get(&loc, `
+        select locationid from event_schedule
+            where discussionid = ? and slotid = ?`,
+		discussions[0].DiscussionID, slots[1].SlotID); err != nil || loc != 1 {
+		t.Errorf("Expected long discussion in location 1, got %v (error %v)", loc, err)
+		return
+	}
+
+	// With the other location taken in the first slot as well, no
+	// location is free for both of the long discussion's slots, so
+	// it must be left out
+	if err := DiscussionSetPin(discussions[1].DiscussionID, slots[0].SlotID, 1); err != nil {
+		t.Errorf("DiscussionSetPin: %v", err)
+		return
+	}
+	data, err := loadSearchData()
+	if err != nil {
+		t.Errorf("loadSearchData: %v", err)
+		return
+	}
+	for d := range data.discussions {
+		if data.discussions[d].id == discussions[0].DiscussionID && data.mayStart(d, 0) {
+			t.Errorf("Long discussion may start with no location free for it")
+			return
+		}
+	}
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly, Validate: true}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	if err := event.Get(&count, `
+        select count(*) from event_schedule where discussionid = ?`,
+		discussions[0].DiscussionID); err != nil || count != 0 {
+		t.Errorf("Expected long discussion unscheduled, got %d entries (error %v)", count, err)
+		return
+	}
+	if err := event.Get(&count, `select count(*) from event_schedule`); err != nil || count != 2 {
+		t.Errorf("Expected 2 schedule entries, got %d (error %v)", count, err)
+		return
+	}
+	if violations, _, err := ValidateSchedule(); err != nil || len(violations) > 0 {
+		t.Errorf("ValidateSchedule: %v (error %v)", violations, err)
+		return
+	}
+
+	return false
+}
+
+func testUnitScheduleOrder(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 2 days * 4 slots * 2 locations
+	if testSetupTimetable(t, 2, 4, 2) {
+		return
+	}
+
+	_, discussions, subexit := testSetupEvent(t, 10, 4)
+	if subexit {
+		return
+	}
+
+	var slots []SlotID
+	for _, ds := range TimetableGetPossibleSlots(discussions[0].DiscussionID) {
+		slots = append(slots, ds.SlotID)
+	}
+	if len(slots) != 8 {
+		t.Errorf("Expected 8 slots, got %d", len(slots))
+		return
+	}
+
+	a, b, c, d := discussions[0].DiscussionID, discussions[1].DiscussionID,
+		discussions[2].DiscussionID, discussions[3].DiscussionID
+
+	t.Logf("Setting invalid orders")
+	if err := DiscussionOrderAdd(a, a, false); err != errOrderSelf {
+		t.Errorf("Ordering a discussion before itself: expected errOrderSelf, got %v", err)
+		return
+	}
+	if err := DiscussionOrderAdd(a, "nonexistent", false); err != ErrDiscussionNotFound {
+		t.Errorf("Expected ErrDiscussionNotFound, got %v", err)
+		return
+	}
+	if err := DiscussionOrderAdd(c, a, false); err != nil {
+		t.Errorf("DiscussionOrderAdd: %v", err)
+		return
+	}
+	if err := DiscussionOrderAdd(a, b, false); err != nil {
+		t.Errorf("DiscussionOrderAdd: %v", err)
+		return
+	}
+	if err := DiscussionOrderAdd(b, c, false); err != errOrderCycle {
+		t.Errorf("Ordering in a cycle: expected errOrderCycle, got %v", err)
+		return
+	}
+	if err := DiscussionOrderRemove(c, a); err != nil {
+		t.Errorf("DiscussionOrderRemove: %v", err)
+		return
+	}
+
+	// a must be on the same day as b, which can only be in the second
+	// slot, so a must be in the first.  c must come before d, which
+	// can only be in the last slot of the first day.
+	if err := DiscussionOrderAdd(a, b, true); err != nil {
+		t.Errorf("DiscussionOrderAdd: %v", err)
+		return
+	}
+	if err := DiscussionOrderAdd(c, d, false); err != nil {
+		t.Errorf("DiscussionOrderAdd: %v", err)
+		return
+	}
+	if orders, err := DiscussionGetOrders(b); err != nil || len(orders) != 1 ||
+		orders[0] != (DiscussionOrder{BeforeID: a, AfterID: b, SameDay: true}) {
+		t.Errorf("DiscussionGetOrders: expected %v before %v on the same day, got %v (error %v)",
+			a, b, orders, err)
+		return
+	}
+	if err := DiscussionSetPossibleSlots(b, slots[1:2]); err != nil {
+		t.Errorf("DiscussionSetPossibleSlots: %v", err)
+		return
+	}
+	if err := DiscussionSetPossibleSlots(d, slots[3:4]); err != nil {
+		t.Errorf("DiscussionSetPossibleSlots: %v", err)
+		return
+	}
+
+	// position returns the index into slots of the first and last
+	// slots discussion did is in, or -1 if it isn't scheduled
+	position := func(did DiscussionID) (first, last int) {
+		first, last = -1, -1
+		for i, slot := range slots {
+			var count int
+			if err := event.Get(&count, `
+                select count(*) from event_schedule
+                    where discussionid = ? and slotid = ?`, did, slot); err != nil {
+				t.Errorf("Getting schedule: %v", err)
+				return
+			}
+			if count > 0 {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		return
+	}
+
+	for _, algo := range []SearchAlgo{SearchHeuristicOnly, SearchRandom, SearchGenetic,
+		SearchAnnealing, SearchExact} {
+		t.Logf("Checking %s", algo)
+		opt := SearchOptions{Algo: algo, Validate: true, MaxIterations: testSearchIterations}
+		if err := MakeSchedule(opt); err != nil {
+			t.Errorf("MakeSchedule: %v", err)
+			return
+		}
+		if violations, _, err := ValidateSchedule(); err != nil || len(violations) > 0 {
+			t.Errorf("ValidateSchedule: %v (error %v)", violations, err)
+			return
+		}
+		if first, _ := position(a); first != 0 {
+			t.Errorf("Expected %v in the first slot, got %d", a, first)
+			return
+		}
+		if first, _ := position(b); first != 1 {
+			t.Errorf("Expected %v in the second slot, got %d", b, first)
+			return
+		}
+		_, cLast := position(c)
+		dFirst, _ := position(d)
+		if cLast < 0 || dFirst != 3 || cLast >= dFirst {
+			t.Errorf("Expected %v before %v in the fourth slot, got %d and %d", c, d, cLast, dFirst)
+			return
+		}
+	}
+
+	t.Logf("Checking that validation catches discussions out of order")
+	var entries []scheduleEntry
+	if err := event.Select(&entries, `
+        select discussionid, slotid, locationid from event_schedule
+            where discussionid in (?, ?)
+            order by discussionid = ?`, a, b, b); err != nil || len(entries) != 2 {
+		t.Errorf("Getting schedule: %v (error %v)", entries, err)
+		return
+	}
+	if _, err := event.Exec(`delete from event_schedule where discussionid in (?, ?)`,
+		a, b); err != nil {
+		t.Errorf("Unscheduling discussions: %v", err)
+		return
+	}
+	// Swap a and b
+	for i := range entries {
+		if _, err := event.Exec(`
+            insert into event_schedule(discussionid, slotid, locationid)
+                values(?, ?, ?)`,
+			entries[i].DiscussionID, entries[1-i].SlotID, entries[1-i].LocationID); err != nil {
+			t.Errorf("Rescheduling discussion: %v", err)
+			return
+		}
+	}
+	violations, _, err := ValidateSchedule()
+	if err != nil {
+		t.Errorf("ValidateSchedule: %v", err)
+		return
+	}
+	found := false
+	for _, v := range violations {
+		found = found || strings.Contains(v, "must end before")
+	}
+	if !found {
+		t.Errorf("Validation didn't catch discussions out of order: %v", violations)
+		return
+	}
+	s, _, err := loadSchedule()
+	if err != nil {
+		t.Errorf("loadSchedule: %v", err)
+		return
+	}
+	if err := s.validate(); err == nil || !strings.Contains(err.Error(), "must end before") {
+		t.Errorf("Validation didn't catch discussions out of order: %v", err)
+		return
+	}
+
+	t.Logf("Removing orders")
+	if err := DiscussionOrderRemove(a, b); err != nil {
+		t.Errorf("DiscussionOrderRemove: %v", err)
+		return
+	}
+	if err := DiscussionSetPossibleSlots(b, slots); err != nil {
+		t.Errorf("DiscussionSetPossibleSlots: %v", err)
+		return
+	}
+	if err := DeleteDiscussion(d); err != nil {
+		t.Errorf("DeleteDiscussion: %v", err)
+		return
+	}
+	for _, did := range []DiscussionID{a, c} {
+		if orders, err := DiscussionGetOrders(did); err != nil || len(orders) != 0 {
+			t.Errorf("Expected no orders for %v, got %v (error %v)", did, orders, err)
+			return
+		}
+	}
+	if violations, _, err := ValidateSchedule(); err != nil || len(violations) != 0 {
+		t.Errorf("ValidateSchedule: %v (error %v)", violations, err)
+		return
+	}
+
+	return false
+}
+
+func testUnitScheduleDryRun(t *testing.T) (exit bool) {
+	exit = true
+
+	tc := dataInit(t)
+	if tc == nil {
+		return
+	}
+	defer tc.cleanup()
+
+	// 1 day * 3 slots * 3 locations
+	if testSetupTimetable(t, 1, 3, 3) {
+		return
+	}
+
+	if _, _, subexit := testSetupEvent(t, 10, 5); subexit {
+		return
+	}
+
+	// With nothing stored, everything is added, and nothing stored
+	dr, err := MakeScheduleDryRun(SearchOptions{Algo: SearchHeuristicOnly})
+	if err != nil {
+		t.Errorf("MakeScheduleDryRun: %v", err)
+		return
+	}
+	if len(dr.Diff.Added) != 5 || len(dr.Diff.Moved)+len(dr.Diff.Removed) != 0 {
+		t.Errorf("Expected 5 discussions added, got %v", dr.Diff)
+		return
+	}
+	if len(dr.Violations) > 0 {
+		t.Errorf("Unexpected violations: %v", dr.Violations)
+		return
+	}
+	count := 0
+	for _, day := range dr.Timetable.Days {
+		for _, slot := range day.Slots {
+			count += len(slot.Discussions)
+		}
+	}
+	if count != 5 {
+		t.Errorf("Expected 5 discussions in the timetable, got %d", count)
+		return
+	}
+	if testCheckStoredSchedule(t, 0) {
+		return
+	}
+	if testExpectSchedState(t, SchedStateModified) {
+		return
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return
+	}
+	_, stored, err := loadSchedule()
+	if err != nil {
+		t.Errorf("loadSchedule: %v", err)
+		return
+	}
+
+	// The same search again makes no changes
+	dr, err = MakeScheduleDryRun(SearchOptions{Algo: SearchHeuristicOnly})
+	if err != nil {
+		t.Errorf("MakeScheduleDryRun: %v", err)
+		return
+	}
+	if len(dr.Diff.Moved)+len(dr.Diff.Added)+len(dr.Diff.Removed) != 0 {
+		t.Errorf("Expected no differences, got %v", dr.Diff)
+		return
+	}
+
+	disc, subexit := testNewDiscussion(t, "")
+	if subexit {
+		return
+	}
+	if err := DiscussionSetPublic(disc.DiscussionID, true); err != nil {
+		t.Errorf("DiscussionSetPublic: %v", err)
+		return
+	}
+	dr, err = MakeScheduleDryRun(SearchOptions{Algo: SearchRandom,
+		MaxIterations: testSearchIterations})
+	if err != nil {
+		t.Errorf("MakeScheduleDryRun: %v", err)
+		return
+	}
+	found := false
+	for _, c := range dr.Diff.Added {
+		found = found || c.DiscussionID == disc.DiscussionID
+	}
+	if !found {
+		t.Errorf("Expected %v to be added, got %v", disc.DiscussionID, dr.Diff)
+		return
+	}
+
+	// The stored schedule, its versions and its state are untouched
+	_, after, err := loadSchedule()
+	if err != nil {
+		t.Errorf("loadSchedule: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(stored, after) {
+		t.Errorf("Dry run changed stored schedule from %v to %v", stored, after)
+		return
+	}
+	versions, err := ScheduleVersionGetAll()
+	if err != nil || len(versions) != 1 {
+		t.Errorf("Expected 1 version, got %v (error %v)", versions, err)
+		return
+	}
+	if testExpectSchedState(t, SchedStateModified) {
+		return
+	}
+
+	return false
+}
@@ -0,0 +1,371 @@
+package event
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Every time a schedule is stored, a copy is kept as a numbered
+// version, so that runs can be compared and earlier schedules
+// restored.
+
+type ScheduleVersion struct {
+	Version    int
+	Created    DBTime
+	Algo       string
+	Options    string
+	Seed       int64 // 0 for rollbacks
+	Iterations int   // 0 for rollbacks
+	Score      int   // Total utility when stored
+	Objective  string
+	MinPercent int // See UserReport.Percent
+}
+
+// recordVersionTx copies the current contents of event_schedule into
+// a new version, returning its number.  Discussions which take several
+// slots are recorded by their first.
+func recordVersionTx(tx *sqlx.Tx, v *ScheduleVersion) (int, error) {
+	res, err := tx.Exec(`
+        insert into event_schedule_versions(created, algo, options, seed, iterations, score,
+                                            objective, minpercent)
+            values(?, ?, ?, ?, ?, ?, ?, ?)`,
+		DBTime{time.Now()}, v.Algo, v.Options, v.Seed, v.Iterations, v.Score,
+		v.Objective, v.MinPercent)
+	if err != nil {
+		return 0, err
+	}
+	version, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+        insert into event_schedule_version_entries(version, discussionid, title, slotid, locationid)
+            select ?, e.discussionid, title, e.slotid, e.locationid
+                from event_schedule as e
+                    join event_discussions using(discussionid)
+                    join event_slots as s using(slotid)
+                where not exists (
+                    select 1 from event_schedule as e2
+                        join event_slots as s2 using(slotid)
+                    where e2.discussionid = e.discussionid
+                      and (s2.dayid < s.dayid or
+                           (s2.dayid = s.dayid and s2.slotidx < s.slotidx)))`,
+		version)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(version), nil
+}
+
+// ScheduleVersionGetAll returns all schedule versions, newest first.
+func ScheduleVersionGetAll() (versions []ScheduleVersion, err error) {
+	for {
+		err = event.Select(&versions, `
+            select * from event_schedule_versions order by version desc`)
+		switch {
+		case shouldRetry(err):
+			continue
+		default:
+			return versions, err
+		}
+	}
+}
+
+// A ScheduleChange describes where a discussion was in one version
+// of the schedule and where it is in another.  From or To is "" if
+// it isn't scheduled in that version.
+type ScheduleChange struct {
+	DiscussionID DiscussionID
+	Title        string
+	From         string
+	To           string
+}
+
+type ScheduleDiff struct {
+	From    int
+	To      int
+	Moved   []ScheduleChange
+	Added   []ScheduleChange
+	Removed []ScheduleChange
+}
+
+type versionEntry struct {
+	DiscussionID DiscussionID
+	Title        string
+	SlotID       SlotID
+	LocationID   LocationID
+	Position     string
+}
+
+// versionEntryRow is a versionEntry, with what's needed to describe
+// its position; any of them may be missing if the slot or location
+// has since been deleted.
+type versionEntryRow struct {
+	versionEntry
+	DayName      *string
+	SlotTime     *DBTime
+	LocationName *string
+}
+
+func versionEntryMap(rows []versionEntryRow) map[DiscussionID]versionEntry {
+	m := make(map[DiscussionID]versionEntry, len(rows))
+	for _, e := range rows {
+		ve := e.versionEntry
+		if e.DayName != nil && e.SlotTime != nil {
+			ve.Position = *e.DayName + " " + formatSlotTime(*e.SlotTime)
+		} else {
+			ve.Position = fmt.Sprintf("Slot %v", ve.SlotID)
+		}
+		if e.LocationName != nil {
+			ve.Position += ", " + *e.LocationName
+		} else {
+			ve.Position += fmt.Sprintf(", location %v", ve.LocationID)
+		}
+		m[ve.DiscussionID] = ve
+	}
+	return m
+}
+
+func versionEntriesTx(q sqlx.Queryer, version int) (map[DiscussionID]versionEntry, error) {
+	var count int
+	if err := sqlx.Get(q, &count,
+		`select count(*) from event_schedule_versions where version = ?`, version); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrVersionNotFound
+	}
+
+	var rows []versionEntryRow
+	if err := sqlx.Select(q, &rows, `
+        select v.discussionid, v.title, v.slotid, v.locationid,
+               dayname, slottime, locationname
+            from event_schedule_version_entries as v
+                left join event_slots using(slotid)
+                left join event_days using(dayid)
+                left join event_locations using(locationid)
+            where version = ?`, version); err != nil {
+		return nil, err
+	}
+
+	return versionEntryMap(rows), nil
+}
+
+// storedEntriesTx is versionEntriesTx for the contents of
+// event_schedule.
+func storedEntriesTx(q sqlx.Queryer) (map[DiscussionID]versionEntry, error) {
+	var rows []versionEntryRow
+	if err := sqlx.Select(q, &rows, `
+        select e.discussionid, title, e.slotid, e.locationid,
+               dayname, slottime, locationname
+            from event_schedule as e
+                join event_discussions using(discussionid)
+                join event_slots as s using(slotid)
+                left join event_days using(dayid)
+                left join event_locations using(locationid)
+            where not exists (
+                select 1 from event_schedule as e2
+                    join event_slots as s2 using(slotid)
+                where e2.discussionid = e.discussionid
+                  and (s2.dayid < s.dayid or
+                       (s2.dayid = s.dayid and s2.slotidx < s.slotidx)))`); err != nil {
+		return nil, err
+	}
+
+	return versionEntryMap(rows), nil
+}
+
+// diffEntries lists the discussions moved, added and removed between
+// fromEntries and toEntries.
+func diffEntries(fromEntries, toEntries map[DiscussionID]versionEntry) (diff ScheduleDiff) {
+	for did, f := range fromEntries {
+		t, prs := toEntries[did]
+		switch {
+		case !prs:
+			diff.Removed = append(diff.Removed, ScheduleChange{
+				DiscussionID: did, Title: f.Title, From: f.Position})
+		case f.SlotID != t.SlotID || f.LocationID != t.LocationID:
+			diff.Moved = append(diff.Moved, ScheduleChange{
+				DiscussionID: did, Title: t.Title, From: f.Position, To: t.Position})
+		}
+	}
+	for did, t := range toEntries {
+		if _, prs := fromEntries[did]; !prs {
+			diff.Added = append(diff.Added, ScheduleChange{
+				DiscussionID: did, Title: t.Title, To: t.Position})
+		}
+	}
+	for _, changes := range [][]ScheduleChange{diff.Moved, diff.Added, diff.Removed} {
+		sortChanges(changes)
+	}
+	return
+}
+
+// ScheduleVersionDiff lists the discussions moved, added and removed
+// between versions from and to.
+func ScheduleVersionDiff(from, to int) (*ScheduleDiff, error) {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		fromEntries, err := versionEntriesTx(tx, from)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		toEntries, err := versionEntriesTx(tx, to)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		diff := diffEntries(fromEntries, toEntries)
+		diff.From, diff.To = from, to
+
+		return &diff, nil
+	}
+}
+
+func sortChanges(changes []ScheduleChange) {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Title < changes[j].Title })
+}
+
+func scheduleRollbackTx(tx *sqlx.Tx, version int) error {
+	var count int
+	if err := tx.Get(&count,
+		`select count(*) from event_schedule_versions where version = ?`, version); err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrVersionNotFound
+	}
+
+	// Discussions in locked slots stay as they are
+	_, err := tx.Exec(`
+        delete from event_schedule
+            where discussionid not in (
+                select discussionid from event_schedule natural join event_slots
+                    where islocked = true)`)
+	if err != nil {
+		return err
+	}
+
+	// Discussions, slots and locations may have changed since the
+	// version was stored: only restore what can still be scheduled.
+	// Each discussion takes as many slots from the one recorded as
+	// its length; those which no longer fit are dropped.
+	_, err = tx.Exec(`
+        insert or ignore into event_schedule(discussionid, slotid, locationid)
+            select v.discussionid, s2.slotid, v.locationid
+                from event_schedule_version_entries as v
+                    join event_discussions as d on d.discussionid = v.discussionid
+                    join event_slots as s on s.slotid = v.slotid
+                    join event_slots as s2 on s2.dayid = s.dayid
+                        and s2.slotidx >= s.slotidx and s2.slotidx < s.slotidx + d.length
+                    join event_locations as l on l.locationid = v.locationid
+                where v.version = ?
+                  and d.ispublic = true
+                  and s2.isbreak = false and s2.islocked = false
+                  and l.isplace = true
+                  and v.discussionid not in (select discussionid from event_schedule)`,
+		version)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+        delete from event_schedule
+            where discussionid in (
+                select discussionid from event_schedule natural join event_discussions
+                    group by discussionid
+                    having count(*) != max(length))`)
+	if err != nil {
+		return err
+	}
+
+	data, err := loadSearchDataTx(tx)
+	if err != nil {
+		return err
+	}
+	entries, err := loadScheduleEntriesTx(tx)
+	if err != nil {
+		return err
+	}
+	restored := data.scheduleFromEntries(entries)
+	_, err = recordVersionTx(tx, &ScheduleVersion{
+		Algo:       "rollback",
+		Options:    fmt.Sprintf("Rolled back to version %d", version),
+		Score:      restored.utility(),
+		MinPercent: restored.minPercent(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The restored schedule wasn't made from the current data, so
+	// mark it stale
+	_, err = tx.Exec(`
+        update event_schedule_state
+            set running = false, generation = generation + 1, lastupdate = ?`,
+		DBTime{time.Now()})
+	return err
+}
+
+// ScheduleRollback restores the schedule stored as version, except
+// for locked slots, which are left as they are.  Discussions which
+// can no longer be scheduled where they were are left unscheduled.
+// The result is stored as a new version.
+func ScheduleRollback(version int) error {
+	if err := schedSetRunning(); err != nil {
+		return err
+	}
+
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			schedClearRunning()
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		err = scheduleRollbackTx(tx, version)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			tx.Rollback()
+			schedClearRunning()
+			return err
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			schedClearRunning()
+			return err
+		}
+
+		return nil
+	}
+}
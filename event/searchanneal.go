@@ -0,0 +1,106 @@
+package event
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	annealSamples = 200 // Moves sampled to pick the starting temperature
+	// Temperature at the end, as a fraction of the starting temperature
+	annealFinalRatio = 0.001
+)
+
+// initialTemperature returns the average size of a score change
+// made by a random move, so that at the start of the search a typical
+// worsening move is accepted with probability of about 1/e.
+func (s *schedule) initialTemperature(rng *rand.Rand) float64 {
+	score := s.score()
+	total, n := 0, 0
+	for i := 0; i < annealSamples; i++ {
+		undo := s.randomMove(rng)
+		if undo == nil {
+			continue
+		}
+		delta := s.score() - score
+		if delta < 0 {
+			delta = -delta
+		}
+		total += delta
+		n++
+		undo()
+	}
+	if n == 0 || total == 0 {
+		return 1
+	}
+	return float64(total) / float64(n)
+}
+
+// annealSearch starts from the heuristic schedule and does simulated
+// annealing: random moves which improve the score are always kept,
+// and moves which make it worse are kept with a probability which
+// falls as the temperature cools.  The temperature falls
+// exponentially from its starting value to annealFinalRatio of it
+// over the search's budget (see searchRun.budgetUsed).  Every
+// exchangeInterval iterations the best schedule is shared with other
+// workers, and if another worker has found a better one, annealing
+// continues from that.
+func annealSearch(data *searchData, w *searchWorker) *schedule {
+	rng := w.rng
+	start := time.Now()
+
+	s := heuristicSearch(data)
+	score := s.score()
+	best, bestScore := s.clone(), score
+
+	t0 := s.initialTemperature(rng)
+	opt.Debug.Printf("Annealing: initial score %d, temperature %.1f", score, t0)
+
+	iterations, accepted := 0, 0
+	for {
+		frac := curRun.budgetUsed(start)
+		if !(frac < 1) || curRun.cancelled() {
+			break
+		}
+		temp := t0 * math.Pow(annealFinalRatio, frac)
+
+		iterations++
+		curRun.report(1, bestScore)
+		if iterations%exchangeInterval == 0 {
+			if shared := w.share(best, bestScore); shared != nil {
+				s, score = shared, shared.score()
+				best, bestScore = s.clone(), score
+			}
+		}
+		undo := s.randomMove(rng)
+		if undo == nil {
+			continue
+		}
+
+		next := s.score()
+		delta := next - score
+		if delta < 0 && rng.Float64() >= math.Exp(float64(delta)/temp) {
+			undo()
+			continue
+		}
+
+		accepted++
+		score = next
+		if score > bestScore {
+			best, bestScore = s.clone(), score
+			if opt.DebugLevel > 1 {
+				opt.Debug.Printf("  New best %d at temperature %.2f", bestScore, temp)
+			}
+		}
+
+		if opt.DebugLevel > 0 && iterations%10000 == 0 {
+			opt.Debug.Printf("Iteration %d: temperature %.2f, score %d, best %d, accepted %d",
+				iterations, temp, score, bestScore, accepted)
+		}
+	}
+
+	opt.Debug.Printf("Annealing: best score %d, %d iterations (%d accepted) in %v",
+		bestScore, iterations, accepted, time.Since(start))
+	return best
+}
@@ -0,0 +1,172 @@
+package event
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// Default maximum search space for the exact search
+const ExactDefaultLimit = 1e9
+
+// searchSpace returns the number of possible schedules: each
+// discussion not in a locked slot can start in any slot it's allowed
+// to (see mayStart), or be left unscheduled.
+func (data *searchData) searchSpace() float64 {
+	space := 1.0
+	for i := range data.discussions {
+		if data.discussions[i].lockedSlot >= 0 {
+			continue
+		}
+		choices := 1
+		for slot := range data.slots {
+			if data.mayStart(i, slot) {
+				choices++
+			}
+		}
+		space *= float64(choices)
+	}
+	return space
+}
+
+// upperBound returns an upper bound on the score of any schedule.
+// Each user can attend at most one discussion per slot, so can get at
+// most the sum of their highest len(slots) interests.
+func (data *searchData) upperBound() int {
+	userInterest := make([][]int, len(data.users))
+	for _, disc := range data.discussions {
+		for _, ui := range disc.interest {
+			userInterest[ui.user] = append(userInterest[ui.user], ui.interest)
+		}
+	}
+
+	bound := 0
+	for _, interest := range userInterest {
+		sort.Sort(sort.Reverse(sort.IntSlice(interest)))
+		for i := 0; i < len(interest) && i < len(data.slots); i++ {
+			bound += interest[i]
+		}
+	}
+	return bound
+}
+
+type exactSearch struct {
+	s         *schedule
+	order     []int // Discussions, in the order they're decided
+	remaining []int // remaining[i]: sum of maxScore for order[i:]
+	best      *schedule
+	bestScore int
+	nodes     int
+	deadline  time.Time
+	timedOut  bool
+}
+
+func (e *exactSearch) search(i int) {
+	if e.timedOut {
+		return
+	}
+	e.nodes++
+	if e.nodes%1024 == 0 {
+		curRun.report(1024, e.bestScore)
+		if curRun.stopped(e.deadline) {
+			e.timedOut = true
+			return
+		}
+	}
+
+	score := e.s.score()
+	if i == len(e.order) {
+		if score > e.bestScore {
+			e.best, e.bestScore = e.s.clone(), score
+			if opt.DebugLevel > 1 {
+				opt.Debug.Printf("  New best %d after %d nodes", score, e.nodes)
+			}
+		}
+		return
+	}
+
+	// Adding a discussion can at best add the full interest of
+	// everyone interested in it.  (The churn and track penalties
+	// can only make things worse, so the bound ignores them.)
+	if e.s.data.objectiveBound(e.s.utility()+e.remaining[i]) <= e.bestScore {
+		return
+	}
+
+	d := e.order[i]
+	for slot := range e.s.data.slots {
+		if e.s.canAssign(d, slot) {
+			e.s.assign(d, slot)
+			e.search(i + 1)
+			e.s.unassign(d)
+		}
+	}
+	// ...or leave it unscheduled
+	e.search(i + 1)
+}
+
+// exactSearchSchedule finds an optimal schedule by branch and bound,
+// using the heuristic schedule as the initial lower bound.  It
+// returns ErrSearchSpaceTooLarge if there are more than opt.ExactLimit
+// possible schedules.  If opt.SearchDuration passes before the search
+// is complete, the best schedule found so far is returned, and the
+// gap between it and the upper bound is reported.
+func exactSearchSchedule(data *searchData) (*schedule, error) {
+	limit := opt.ExactLimit
+	if limit == 0 {
+		limit = ExactDefaultLimit
+	}
+	if space := data.searchSpace(); space > limit {
+		log.Printf("Exact search: search space %g exceeds limit %g", space, limit)
+		return nil, ErrSearchSpaceTooLarge
+	}
+
+	start := time.Now()
+	e := exactSearch{
+		s:        newSchedule(data),
+		deadline: start.Add(opt.SearchDuration),
+	}
+	e.best = heuristicSearch(data)
+	e.bestScore = e.best.score()
+
+	// Deciding the most popular discussions first makes the bound
+	// shrink fastest.  Discussions in locked slots are already
+	// decided.
+	for d := range data.discussions {
+		if e.s.movable(d) {
+			e.order = append(e.order, d)
+		}
+	}
+	sort.SliceStable(e.order, func(i, j int) bool {
+		return data.discussions[e.order[i]].maxScore >
+			data.discussions[e.order[j]].maxScore
+	})
+	e.remaining = make([]int, len(e.order)+1)
+	for i := len(e.order) - 1; i >= 0; i-- {
+		e.remaining[i] = e.remaining[i+1] + data.discussions[e.order[i]].maxScore
+	}
+
+	// Discussions already decided count towards the bound, as they
+	// do when pruning
+	fixed := e.s.utility()
+
+	e.search(0)
+
+	// The bound is in the same units as the score, whatever the
+	// objective
+	bound := e.bestScore
+	if e.timedOut {
+		utilityBound := data.upperBound()
+		if fixed+e.remaining[0] < utilityBound {
+			utilityBound = fixed + e.remaining[0]
+		}
+		bound = data.objectiveBound(utilityBound)
+	}
+	gap := 0.0
+	if bound > 0 {
+		gap = float64(bound-e.bestScore) / float64(bound) * 100
+	}
+	log.Printf("Exact search: score %d, upper bound %d, optimality gap %.2f%% (%d nodes in %v, complete %v)",
+		e.bestScore, bound, gap, e.nodes, time.Since(start), !e.timedOut)
+
+	return e.best, nil
+}
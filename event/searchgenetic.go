@@ -0,0 +1,125 @@
+package event
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	geneticPopulation     = 40
+	geneticElite          = 4 // Best individuals copied unchanged to the next generation
+	geneticTournament     = 3
+	geneticMutationMoves  = 3
+	geneticMutationChance = 4 // 1 in N children are mutated
+	// Generations between migrations of the best schedule between
+	// workers' populations
+	geneticMigrationInterval = 10
+)
+
+type individual struct {
+	s     *schedule
+	score int
+}
+
+// crossover makes a child schedule which takes the slot for each
+// discussion from one parent or the other at random.  Discussions
+// which don't fit where their chosen parent had them are then placed
+// randomly.  Discussions in locked slots are already in place.
+func crossover(a, b *schedule, rng *rand.Rand) *schedule {
+	c := newSchedule(a.data)
+	for _, d := range rng.Perm(len(c.slotOf)) {
+		if !c.movable(d) {
+			continue
+		}
+		slot := a.slotOf[d]
+		if rng.Intn(2) == 0 {
+			slot = b.slotOf[d]
+		}
+		if slot >= 0 && c.canAssign(d, slot) {
+			c.assign(d, slot)
+		}
+	}
+	c.placeRandom(rng)
+	return c
+}
+
+func (s *schedule) mutate(rng *rand.Rand) {
+	for i := 0; i < geneticMutationMoves; i++ {
+		s.randomMove(rng)
+	}
+}
+
+// tournament picks geneticTournament individuals at random, and
+// returns the best of them.
+func tournament(population []individual, rng *rand.Rand) *schedule {
+	best := population[rng.Intn(len(population))]
+	for i := 1; i < geneticTournament; i++ {
+		c := population[rng.Intn(len(population))]
+		if c.score > best.score {
+			best = c
+		}
+	}
+	return best.s
+}
+
+// geneticSearch evolves a population of schedules until
+// opt.SearchDuration has passed.  The initial population is the
+// heuristic schedule plus random schedules.  Each worker evolves its
+// own population (island); every geneticMigrationInterval
+// generations, the best schedule found by any worker replaces the
+// worst in the population.
+func geneticSearch(data *searchData, w *searchWorker) *schedule {
+	rng := w.rng
+	start := time.Now()
+	deadline := start.Add(opt.SearchDuration)
+
+	population := make([]individual, geneticPopulation)
+	for i := range population {
+		var s *schedule
+		if i == 0 {
+			s = heuristicSearch(data)
+		} else {
+			s = newSchedule(data)
+			s.placeRandom(rng)
+		}
+		population[i] = individual{s: s, score: s.score()}
+	}
+
+	byScore := func(p []individual) {
+		sort.SliceStable(p, func(i, j int) bool { return p[i].score > p[j].score })
+	}
+	byScore(population)
+
+	generation := 0
+	for ; !curRun.stopped(deadline); generation++ {
+		next := make([]individual, 0, geneticPopulation)
+		next = append(next, population[:geneticElite]...)
+		for len(next) < geneticPopulation {
+			c := crossover(tournament(population, rng), tournament(population, rng), rng)
+			if rng.Intn(geneticMutationChance) == 0 {
+				c.mutate(rng)
+			}
+			next = append(next, individual{s: c, score: c.score()})
+		}
+		byScore(next)
+		curRun.report(len(next)-geneticElite, next[0].score)
+
+		if opt.DebugLevel > 1 || (opt.DebugLevel > 0 && next[0].score > population[0].score) {
+			opt.Debug.Printf("Generation %d: best %d, median %d",
+				generation, next[0].score, next[len(next)/2].score)
+		}
+		population = next
+
+		if generation%geneticMigrationInterval == geneticMigrationInterval-1 {
+			if shared := w.share(population[0].s, population[0].score); shared != nil {
+				population[len(population)-1] = individual{s: shared, score: shared.score()}
+				byScore(population)
+			}
+		}
+	}
+
+	opt.Debug.Printf("Genetic search: best score %d after %d generations in %v",
+		population[0].score, generation, time.Since(start))
+	return population[0].s
+}
@@ -0,0 +1,128 @@
+package event
+
+import "sort"
+
+// gain returns how much total utility would increase if discussion d
+// were added to slot, summed over every slot it would take.  Only
+// users available during each slot count.
+func (s *schedule) gain(d, slot int) int {
+	sd := &s.data.discussions[d]
+	gain := 0
+	for k := 0; k < sd.length; k++ {
+		best := make(map[int]int)
+		for od := range s.slotOf {
+			if od == d || !s.inSlot(od, slot+k) {
+				continue
+			}
+			osd := &s.data.discussions[od]
+			for _, ui := range osd.interest {
+				if i := osd.share(ui.interest, slot+k-s.slotOf[od]); i > best[ui.user] {
+					best[ui.user] = i
+				}
+			}
+		}
+
+		for _, ui := range sd.interest {
+			if !s.data.available(ui.user, slot+k) {
+				continue
+			}
+			if i := sd.share(ui.interest, k); i > best[ui.user] {
+				gain += i - best[ui.user]
+			}
+		}
+	}
+	return gain
+}
+
+// trackCost returns how much the track penalty would increase if
+// discussion d were added to slot.
+func (s *schedule) trackCost(d, slot int) int {
+	if len(s.data.discussions[d].tracks) == 0 {
+		return 0
+	}
+	shared := 0
+	for k := slot; k < slot+s.data.discussions[d].length; k++ {
+		for od := range s.slotOf {
+			if od != d && s.inSlot(od, k) {
+				shared += s.data.sharedTracks(d, od)
+			}
+		}
+	}
+	return opt.TrackWeight * shared
+}
+
+// scoreGain returns how much the score would change if discussion d
+// were added to slot.  For total utility this is quick to work out;
+// for the other objectives the schedule is scored with and without d.
+func (s *schedule) scoreGain(d, slot int) int {
+	if opt.Objective == ObjectiveTotal {
+		return s.gain(d, slot) - s.trackCost(d, slot)
+	}
+	before := s.score()
+	s.assign(d, slot)
+	after := s.score()
+	s.unassign(d)
+	return after - before
+}
+
+// placeGreedy schedules every unscheduled discussion, most popular
+// first, into whichever slot it would add the most score to.  Ties
+// go to the emptiest slot, and then to the earliest.  Discussions for
+// which there is no room are left unscheduled.
+func (s *schedule) placeGreedy() {
+	var order []int
+	for d, slot := range s.slotOf {
+		if slot < 0 {
+			order = append(order, d)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return s.data.discussions[order[i]].maxScore >
+			s.data.discussions[order[j]].maxScore
+	})
+
+	for _, d := range order {
+		bestSlot, bestGain := -1, 0
+		for slot := range s.data.slots {
+			if !s.canAssign(d, slot) {
+				continue
+			}
+			g := s.scoreGain(d, slot)
+			if bestSlot < 0 || g > bestGain ||
+				(g == bestGain && s.count[slot] < s.count[bestSlot]) {
+				bestSlot, bestGain = slot, g
+			}
+		}
+		if bestSlot < 0 {
+			opt.Debug.Printf("No room for discussion %s", s.data.discussions[d].title)
+			continue
+		}
+		s.assign(d, bestSlot)
+	}
+	curRun.report(len(order), s.score())
+}
+
+// placeBaseline puts every unscheduled discussion back where it is in
+// the stored schedule, if it's still allowed to be there.
+func (s *schedule) placeBaseline() {
+	for d, slot := range s.slotOf {
+		b := s.data.discussions[d].baselineSlot
+		if slot < 0 && b >= 0 && s.canAssign(d, b) {
+			s.assign(d, b)
+		}
+	}
+}
+
+// heuristicSearch places discussions greedily.  When rescheduling
+// incrementally it starts from the stored schedule, so that only new
+// discussions, and those which can no longer stay where they were,
+// are placed.
+func heuristicSearch(data *searchData) *schedule {
+	s := newSchedule(data)
+	if opt.Incremental {
+		s.placeBaseline()
+	}
+	s.placeGreedy()
+	opt.Debug.Printf("Heuristic schedule score %d", s.score())
+	return s
+}
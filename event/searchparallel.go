@@ -0,0 +1,88 @@
+package event
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Searches can be run by several workers in parallel, each doing an
+// independent search over the same (immutable) searchData.  Workers
+// periodically offer their best schedule to the run, and may take up
+// a better one found by another worker.  With more than one worker,
+// searches aren't reproducible, since what is exchanged depends on
+// timing.
+
+// Iterations between exchanges of the best schedule
+const exchangeInterval = 2000
+
+type searchWorker struct {
+	id  int
+	rng *rand.Rand
+}
+
+// Worker 0 uses opt.Seed itself, so that a single worker gives the
+// same results as an unparallelized search.
+func newSearchWorker(id int) *searchWorker {
+	return &searchWorker{
+		id:  id,
+		rng: rand.New(rand.NewSource(opt.Seed + int64(id))),
+	}
+}
+
+// offer records s as a candidate for the best schedule found by any
+// worker.
+func (r *searchRun) offer(s *schedule, score int) {
+	r.Lock()
+	defer r.Unlock()
+	if r.best == nil || score > r.bestScore {
+		r.best, r.bestScore = s.clone(), score
+	}
+}
+
+// share offers s, the best schedule this worker has found, and
+// returns a copy of the best schedule any worker has found if that is
+// better; or nil otherwise.
+func (w *searchWorker) share(s *schedule, score int) *schedule {
+	curRun.offer(s, score)
+	curRun.Lock()
+	defer curRun.Unlock()
+	if curRun.bestScore > score {
+		opt.Debug.Printf("Worker %d: taking shared schedule with score %d (had %d)",
+			w.id, curRun.bestScore, score)
+		return curRun.best.clone()
+	}
+	return nil
+}
+
+// runWorkers runs opt.Workers copies of search in parallel, and
+// returns the best schedule any of them found.
+func runWorkers(data *searchData, search func(*searchData, *searchWorker) *schedule) *schedule {
+	n := opt.Workers
+	if n < 1 {
+		n = 1
+	}
+
+	results := make([]*schedule, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = search(data, newSearchWorker(i))
+		}(i)
+	}
+	wg.Wait()
+
+	var best *schedule
+	bestScore := 0
+	for i, s := range results {
+		score := s.score()
+		if n > 1 {
+			opt.Debug.Printf("Worker %d: score %d", i, score)
+		}
+		if best == nil || score > bestScore {
+			best, bestScore = s, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,172 @@
+package event
+
+import (
+	"math/rand"
+	"time"
+)
+
+// reassign moves discussion d to slot, which may be -1 (unscheduled).
+func (s *schedule) reassign(d, slot int) {
+	s.unassign(d)
+	if slot >= 0 {
+		s.assign(d, slot)
+	}
+}
+
+// randomMove makes a random change to the schedule: either moving a
+// discussion to a slot with space, or swapping it with one of the
+// discussions already in that slot.  It returns a function which
+// undoes the change, or nil if the change chosen wasn't possible.
+func (s *schedule) randomMove(rng *rand.Rand) (undo func()) {
+	if len(s.slotOf) == 0 {
+		return nil
+	}
+
+	d := rng.Intn(len(s.slotOf))
+	from := s.slotOf[d]
+	to := rng.Intn(len(s.data.slots))
+	if to == from || !s.movable(d) || s.data.slots[to].locked {
+		return nil
+	}
+
+	s.unassign(d)
+	if s.canAssign(d, to) {
+		s.assign(d, to)
+		return func() { s.reassign(d, from) }
+	}
+
+	// Discussions taking several slots may have started before to
+	var others []int
+	for od := range s.slotOf {
+		if s.inSlot(od, to) && s.movable(od) {
+			others = append(others, od)
+		}
+	}
+	if len(others) == 0 {
+		s.reassign(d, from)
+		return nil
+	}
+
+	e := others[rng.Intn(len(others))]
+	eFrom := s.slotOf[e]
+	s.unassign(e)
+	if s.canAssign(d, to) {
+		s.assign(d, to)
+		if from < 0 || s.canAssign(e, from) {
+			s.reassign(e, from)
+			return func() {
+				s.unassign(d)
+				s.reassign(e, eFrom)
+				s.reassign(d, from)
+			}
+		}
+		s.unassign(d)
+	}
+	s.assign(e, eFrom)
+	s.reassign(d, from)
+	return nil
+}
+
+// placeRandom schedules every unscheduled discussion, in random
+// order, into a random slot with space.
+func (s *schedule) placeRandom(rng *rand.Rand) {
+	for _, d := range rng.Perm(len(s.slotOf)) {
+		if s.slotOf[d] >= 0 {
+			continue
+		}
+		var slots []int
+		for slot := range s.data.slots {
+			if s.canAssign(d, slot) {
+				slots = append(slots, slot)
+			}
+		}
+		if len(slots) > 0 {
+			s.assign(d, slots[rng.Intn(len(slots))])
+		}
+	}
+}
+
+// hillClimb makes random moves, keeping those which don't make the
+// score worse, until stagnant moves in a row have failed to improve
+// it or the deadline passes.  Every exchangeInterval moves, it
+// carries on from the best schedule any worker has found instead, if
+// that is better.  It returns the final score and the number of moves
+// tried.
+func (s *schedule) hillClimb(w *searchWorker, deadline time.Time, stagnant int) (score, iterations int) {
+	rng := w.rng
+	score = s.score()
+	for failed := 0; failed < stagnant && !curRun.stopped(deadline); iterations++ {
+		curRun.report(1, score)
+		if iterations > 0 && iterations%exchangeInterval == 0 {
+			if shared := w.share(s, score); shared != nil {
+				*s, score = *shared, shared.score()
+				failed = 0
+			}
+		}
+		undo := s.randomMove(rng)
+		if undo == nil {
+			failed++
+			continue
+		}
+		next := s.score()
+		switch {
+		case next < score:
+			undo()
+			failed++
+		case next == score:
+			// Accept sideways moves, to get across plateaus
+			failed++
+		default:
+			if opt.DebugLevel > 1 {
+				opt.Debug.Printf("  Improved score %d -> %d", score, next)
+			}
+			score = next
+			failed = 0
+		}
+	}
+	return
+}
+
+// randomSearch does random-restart hill-climbing until
+// opt.SearchDuration has passed.  The first worker's first climb
+// starts from the heuristic schedule; all others from random
+// schedules.  Workers exchange their best schedules as they climb
+// (see hillClimb), and offer them after each climb.
+func randomSearch(data *searchData, w *searchWorker) *schedule {
+	rng := w.rng
+	start := time.Now()
+	deadline := start.Add(opt.SearchDuration)
+
+	stagnant := 10 * len(data.discussions) * len(data.slots)
+	if stagnant < 1000 {
+		stagnant = 1000
+	}
+
+	var best *schedule
+	bestScore := 0
+	iterations := 0
+	for restart := 0; restart == 0 || !curRun.stopped(deadline); restart++ {
+		var s *schedule
+		if restart == 0 && w.id == 0 {
+			s = heuristicSearch(data)
+		} else {
+			s = newSchedule(data)
+			s.placeRandom(rng)
+		}
+
+		score, n := s.hillClimb(w, deadline, stagnant)
+		iterations += n
+		if best == nil || score > bestScore {
+			best, bestScore = s, score
+			curRun.offer(best, bestScore)
+		}
+		if opt.DebugLevel > 0 {
+			opt.Debug.Printf("Worker %d restart %d: score %d (best %d) after %v, %d iterations",
+				w.id, restart, score, bestScore, time.Since(start), iterations)
+		}
+	}
+
+	opt.Debug.Printf("Random search: best score %d, %d iterations in %v",
+		bestScore, iterations, time.Since(start))
+	return best
+}
@@ -0,0 +1,268 @@
+package event
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/gwd/session-scheduler/id"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	slotIDLength = 16
+)
+
+type DayID int
+
+type Day struct {
+	DayID   DayID
+	DayName string
+}
+
+type SlotID string
+
+func (sid *SlotID) generate() {
+	*sid = SlotID(id.GenerateID("slot", slotIDLength))
+}
+
+type Slot struct {
+	SlotID   SlotID
+	SlotIdx  int // Order within a day, starting at 1
+	DayID    DayID
+	SlotTime DBTime
+	IsBreak  bool
+	IsLocked bool
+}
+
+// Day IDs are assigned in order, starting at 1.
+func NewDay(day *Day) error {
+	if day.DayName == "" || AllWhitespace(day.DayName) {
+		log.Printf("New day failed: no name")
+		return errNoDayName
+	}
+
+	for {
+		res, err := event.Exec(`insert into event_days(dayname) values(?)`,
+			day.DayName)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err != nil:
+			log.Printf("New day failed: %v", err)
+			return err
+		}
+
+		did, err := res.LastInsertId()
+		if err != nil {
+			log.Printf("ERROR Getting day id: %v", err)
+			return ErrInternal
+		}
+		day.DayID = DayID(did)
+		return nil
+	}
+}
+
+func DayGetAll() (days []Day, err error) {
+	for {
+		err = event.Select(&days, `select * from event_days order by dayid`)
+		switch {
+		case shouldRetry(err):
+			continue
+		default:
+			return days, err
+		}
+	}
+}
+
+// NewSlot adds a slot to the end of slot.DayID.  SlotID and SlotIdx
+// are filled in by this function.
+func NewSlot(slot *Slot) error {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		var count int
+		err = tx.Get(&count, `select count(*) from event_slots where dayid = ?`,
+			slot.DayID)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Getting slot count for day %v: %v", slot.DayID, err)
+		}
+
+		slot.SlotID.generate()
+		slot.SlotIdx = count + 1
+
+		_, err = tx.Exec(`
+        insert into event_slots(slotid, slotidx, dayid, slottime, isbreak, islocked)
+            values(?, ?, ?, ?, ?, ?)`,
+			slot.SlotID, slot.SlotIdx, slot.DayID, slot.SlotTime,
+			slot.IsBreak, slot.IsLocked)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if isErrorForeignKey(err) {
+			return ErrDayNotFound
+		} else if err != nil {
+			return err
+		}
+
+		err = schedInvalidateTx(tx)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Invalidating schedule: %v", err)
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// Return nil for slot not present
+func SlotFindById(sid SlotID) (*Slot, error) {
+	var slot Slot
+	for {
+		err := event.Get(&slot, `select * from event_slots where slotid = ?`, sid)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err == sql.ErrNoRows:
+			return nil, nil
+		case err != nil:
+			return nil, err
+		default:
+			return &slot, nil
+		}
+	}
+}
+
+// SlotGetAll returns all slots, ordered by day and then by order
+// within the day.
+func SlotGetAll() (slots []Slot, err error) {
+	for {
+		err = event.Select(&slots, `select * from event_slots order by dayid, slotidx`)
+		switch {
+		case shouldRetry(err):
+			continue
+		default:
+			return slots, err
+		}
+	}
+}
+
+// LockedSlotsSet locks the listed slots, and all the slots in the
+// listed days, and unlocks all others.  The scheduler won't change
+// which discussions are in a locked slot, or where they are.  Breaks
+// are never locked.
+func LockedSlotsSet(slots []SlotID, days []DayID) error {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		err = lockedSlotsSetTx(tx, slots, days)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+func lockedSlotsSetTx(tx *sqlx.Tx, slots []SlotID, days []DayID) error {
+	_, err := tx.Exec(`update event_slots set islocked = false`)
+	if err != nil {
+		return err
+	}
+
+	for _, sid := range slots {
+		res, err := tx.Exec(`
+            update event_slots set islocked = true
+                where slotid = ? and isbreak = false`, sid)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrSlotNotFound
+		}
+	}
+
+	for _, did := range days {
+		var count int
+		err = tx.Get(&count, `select count(*) from event_days where dayid = ?`, did)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrDayNotFound
+		}
+		_, err = tx.Exec(`
+            update event_slots set islocked = true
+                where dayid = ? and isbreak = false`, did)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A pinned discussion running into a locked slot must already be
+	// scheduled where it's pinned; otherwise whatever is there would
+	// be locked in the way of the pin
+	var conflicts int
+	err = tx.Get(&conflicts, `
+        select count(*)
+            from event_discussion_pins as p
+                join event_discussions as d on d.discussionid = p.discussionid
+                join event_slots as ps on ps.slotid = p.slotid
+                join event_slots as s on s.dayid = ps.dayid
+                    and s.slotidx >= ps.slotidx and s.slotidx < ps.slotidx + d.length
+            where s.islocked = true
+              and not exists (select 1 from event_schedule as e
+                                  where e.discussionid = p.discussionid
+                                    and e.slotid = p.slotid
+                                    and e.locationid = p.locationid)`)
+	if err != nil {
+		return err
+	}
+	if conflicts > 0 {
+		return errLockPinned
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package event
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Discussions can be tagged with the track(s) they belong to
+// (e.g. "security", "arm", "toolstack").  People tend to follow a
+// track, so by default the scheduler tries not to put two discussions
+// from the same track in the same slot.  An admin can turn that off
+// for a track, or ask for a track to be kept in the same room, by
+// storing a row in event_tracks; tracks without a row use
+// defaultTrack.
+
+const (
+	maxTagsPerDiscussion = 5
+	maxTagLength         = 32
+)
+
+var tagRE = regexp.MustCompile("^[a-z0-9][a-z0-9.+_-]*$")
+
+type Track struct {
+	Tag          string
+	AvoidOverlap bool
+	SameRoom     bool
+}
+
+var defaultTrack = Track{AvoidOverlap: true}
+
+// normalizeTags lowercases and trims tags, dropping empty and
+// duplicate ones, and checks that what's left is valid.
+func normalizeTags(tags []string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength || !tagRE.MatchString(tag) {
+			return nil, errInvalidTag
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	if len(out) > maxTagsPerDiscussion {
+		return nil, errTooManyTags
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// TagsParse splits a list of tags separated by commas or spaces, as
+// entered in a form, and checks that they're valid.
+func TagsParse(s string) ([]string, error) {
+	return normalizeTags(strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}))
+}
+
+// DiscussionSetTags replaces the tags of discussion did.
+func DiscussionSetTags(did DiscussionID, tags []string) error {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return err
+	}
+
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		err = discussionSetTagsTx(tx, did, tags)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+func discussionSetTagsTx(tx *sqlx.Tx, did DiscussionID, tags []string) error {
+	var count int
+	err := tx.Get(&count, `select count(*) from event_discussions where discussionid = ?`, did)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrDiscussionNotFound
+	}
+
+	_, err = tx.Exec(`delete from event_discussion_tags where discussionid = ?`, did)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		_, err = tx.Exec(`
+            insert into event_discussion_tags(discussionid, tag) values(?, ?)`,
+			did, tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	return schedInvalidateTx(tx)
+}
+
+// DiscussionGetTags returns the tags of discussion did, in
+// alphabetical order.
+func DiscussionGetTags(did DiscussionID) (tags []string, err error) {
+	for {
+		err = event.Select(&tags, `
+            select tag from event_discussion_tags
+                where discussionid = ?
+                order by tag`, did)
+		if shouldRetry(err) {
+			continue
+		}
+		return
+	}
+}
+
+// DiscussionIterateTag calls f for each discussion tagged with tag.
+func DiscussionIterateTag(tag string, f func(*Discussion) error) (err error) {
+	return discussionIterateQuery(`
+        select event_discussions.* from event_discussions natural join event_discussion_tags
+            where tag = ?
+            order by discussionid`, []interface{}{tag}, f)
+}
+
+// TrackGetAll returns the rules for every tag which is in use, in
+// alphabetical order.
+func TrackGetAll() (tracks []Track, err error) {
+	for {
+		err = event.Select(&tracks, `
+            select tag,
+                   coalesce(avoidoverlap, ?) as avoidoverlap,
+                   coalesce(sameroom, ?) as sameroom
+                from (select distinct tag from event_discussion_tags)
+                  natural left join event_tracks
+                order by tag`, defaultTrack.AvoidOverlap, defaultTrack.SameRoom)
+		if shouldRetry(err) {
+			continue
+		}
+		return
+	}
+}
+
+// TracksSet stores the rules for each of tracks.
+func TracksSet(tracks []Track) error {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		err = tracksSetTx(tx, tracks)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+func tracksSetTx(tx *sqlx.Tx, tracks []Track) error {
+	for _, t := range tracks {
+		if !tagRE.MatchString(t.Tag) || len(t.Tag) > maxTagLength {
+			return errInvalidTag
+		}
+		_, err := tx.Exec(`
+            insert or replace into event_tracks(tag, avoidoverlap, sameroom)
+                values(?, ?, ?)`, t.Tag, t.AvoidOverlap, t.SameRoom)
+		if err != nil {
+			return err
+		}
+	}
+
+	return schedInvalidateTx(tx)
+}
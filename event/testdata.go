@@ -1,79 +1,298 @@
 package event
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
 	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// A synthetic event, for trying out and benchmarking the scheduler.
+// It should be generated into an empty event.
+
+type TestEventOptions struct {
+	Users       int
+	Discussions int
+	Days        int
+	SlotsPerDay int
+	Locations   int
+	// Seed for the random number generator; 0 means pick one from
+	// the clock.  The same seed always generates the same event,
+	// apart from IDs.
+	Seed int64
+}
+
+const (
+	TestUsers     = 8
+	TestDisc      = 6
+	TestDays      = 2
+	TestSlots     = 4
+	TestLocations = 3
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
+// testUserPassword is the password of every synthetic user.
+const testUserPassword = "xenuser"
+
+// LoadTemporary opens a new, empty event in a temporary directory,
+// for a synthetic event.  The returned function closes the event and
+// removes the directory.
+func LoadTemporary(defaultLocation string) (cleanup func(), err error) {
+	dir, err := ioutil.TempDir("", "event")
+	if err != nil {
+		return nil, err
+	}
+	err = Load(EventOptions{
+		DefaultLocation: defaultLocation,
+		dbFilename:      filepath.Join(dir, "event.sqlite3")})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return func() {
+		Close()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// TestPopulate generates a synthetic event: days of slots, with a
+// break in the middle of each; locations of increasing capacity;
+// verified users; discussions, from random owners; and interest (see
+// TestGenerateInterest).  Zero fields in opt take the defaults above.
+func TestPopulate(opt TestEventOptions) error {
+	if opt.Users == 0 {
+		opt.Users = TestUsers
+	}
+	if opt.Discussions == 0 {
+		opt.Discussions = TestDisc
+	}
+	if opt.Days == 0 {
+		opt.Days = TestDays
+	}
+	if opt.SlotsPerDay == 0 {
+		opt.SlotsPerDay = TestSlots
+	}
+	if opt.Locations == 0 {
+		opt.Locations = TestLocations
+	}
+	if opt.Seed == 0 {
+		opt.Seed = time.Now().UnixNano()
+	}
+	if opt.Discussions > opt.Users*maxDiscussionsPerUser {
+		return fmt.Errorf("%d users can own at most %d discussions",
+			opt.Users, opt.Users*maxDiscussionsPerUser)
+	}
+
+	rng := rand.New(rand.NewSource(opt.Seed))
+
+	if err := testPopulateTimetable(opt); err != nil {
+		return err
+	}
+
+	// Every location holds a share of the users, the largest all
+	// of them
+	for i := 0; i < opt.Locations; i++ {
+		loc := Location{
+			LocationName: fmt.Sprintf("Room %d", i+1),
+			IsPlace:      true,
+			Capacity:     (opt.Users*(i+1) + opt.Locations - 1) / opt.Locations,
+		}
+		if err := NewLocation(&loc); err != nil {
+			return fmt.Errorf("Creating location: %v", err)
+		}
+	}
+
+	// Hashing passwords is slow on purpose, so do it once
+	hashedPassword, err := passwordHash(testUserPassword)
+	if err != nil {
+		return fmt.Errorf("Hashing password: %v", err)
+	}
+	users := make([]UserID, opt.Users)
+	for i := range users {
+		user := User{
+			Username:       fmt.Sprintf("testuser%d", i+1),
+			HashedPassword: hashedPassword,
+			RealName:       fmt.Sprintf("Test User %d", i+1),
+			IsVerified:     true,
+		}
+		if users[i], err = NewUser("", &user); err != nil {
+			return fmt.Errorf("Creating user %s: %v", user.Username, err)
+		}
+	}
+
+	owned := make([]int, len(users))
+	for i := 0; i < opt.Discussions; i++ {
+		owner := rng.Intn(len(users))
+		for owned[owner] >= maxDiscussionsPerUser {
+			owner = rng.Intn(len(users))
+		}
+		owned[owner]++
+
+		disc := Discussion{
+			Owner:       users[owner],
+			Title:       fmt.Sprintf("Test discussion %d", i+1),
+			Description: fmt.Sprintf("Synthetic discussion %d, generated with seed %d.", i+1, opt.Seed),
+		}
+		if err := NewDiscussion(&disc); err != nil {
+			return fmt.Errorf("Creating discussion: %v", err)
+		}
+	}
+
+	return TestGenerateInterest(rng.Int63())
+}
+
+// testPopulateTimetable creates opt.Days days of opt.SlotsPerDay
+// hour-long slots, from 9:00 tomorrow, with a half-hour break in the
+// middle of each day.
+func testPopulateTimetable(opt TestEventOptions) error {
+	now := time.Now().In(event.defaultLocation)
+	start := time.Date(now.Year(), now.Month(), now.Day()+1, 9, 0, 0, 0, event.defaultLocation)
+	for i := 0; i < opt.Days; i++ {
+		day := Day{DayName: start.AddDate(0, 0, i).Format("Monday")}
+		if err := NewDay(&day); err != nil {
+			return fmt.Errorf("Creating day: %v", err)
+		}
+
+		slotTime := start.AddDate(0, 0, i)
+		for j := 0; j < opt.SlotsPerDay; j++ {
+			if j > 0 && j == opt.SlotsPerDay/2 {
+				slot := Slot{DayID: day.DayID, SlotTime: DBTime{slotTime}, IsBreak: true}
+				if err := NewSlot(&slot); err != nil {
+					return fmt.Errorf("Creating break: %v", err)
+				}
+				slotTime = slotTime.Add(30 * time.Minute)
+			}
+			slot := Slot{DayID: day.DayID, SlotTime: DBTime{slotTime}}
+			if err := NewSlot(&slot); err != nil {
+				return fmt.Errorf("Creating slot: %v", err)
+			}
+			slotTime = slotTime.Add(time.Hour)
+		}
+	}
+	return nil
 }
 
 // Try to emulate "realistic" interest, where people will be like one another.
 // - Create four "unique" people at the beginning, with random interests
 // - Afterwards, choose someone randomly to emulate 90% of the time.
 // - When emulating somebody, choose like them 7/8 times
-func TestGenerateInterest() {
-	handled := []*User{}
-	UserIterate(func(user *User) error {
-		var model *User
+//
+// The same seed always generates the same interest.  Owners are
+// always interested in their own discussions, so they're skipped.
+func TestGenerateInterest(seed int64) error {
+	rng := rand.New(rand.NewSource(seed))
+
+	var users []User
+	if err := UserIterate(func(user *User) error {
+		if user.Username != AdminUsername {
+			users = append(users, *user)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	var discussions []Discussion
+	if err := DiscussionIterate(func(disc *Discussion) error {
+		discussions = append(discussions, *disc)
+		return nil
+	}); err != nil {
+		return err
+	}
+	// Don't let the random IDs change which interest goes where
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	sort.Slice(discussions, func(i, j int) bool {
+		return discussions[i].Title < discussions[j].Title
+	})
+
+	interest := make([][]int, len(users))
+	for i := range users {
+		interest[i] = make([]int, len(discussions))
+
 		// Create 4 random "models" at first; after that, 10% are random
-		if len(handled) > 4 && rand.Intn(10) != 0 {
-			model = handled[rand.Intn(len(handled))]
-			log.Printf("User %s will follow model %s",
-				user.Username, model.Username)
-		} else {
-			log.Printf("User %s will be themselves", user.Username)
+		model := -1
+		if i >= 4 && rng.Intn(10) != 0 {
+			model = rng.Intn(i)
 		}
-		DiscussionIterate(func(disc *Discussion) error {
-			r := rand.Intn(100)
-			interest := 0
+
+		for j := range discussions {
+			r := rng.Intn(100)
 
 			// If we don't have a model, or feel like it (12.5%), do
 			// our own thing; otherwise emulate our model.
-			if model == nil || rand.Intn(8) == 0 {
+			if model < 0 || rng.Intn(8) == 0 {
 				switch {
+				case r >= 90:
+					interest[i][j] = InterestMax
 				case r >= 40:
-					interest = rand.Intn(100)
-				case r >= 50:
-					interest = 100
+					interest[i][j] = rng.Intn(InterestMax) + 1
 				}
-				log.Print(" Choosing own interest")
 			} else {
-				// FIXME: Interest
-				//interest = model.Interest[disc.ID]
-				interest = 0
-				log.Print(" Following mentor's interest")
+				interest[i][j] = interest[model][j]
 			}
+		}
+	}
 
-			log.Printf("Setting uid %s interest in discussion %s to %d",
-				user.Username, disc.Title, interest)
-			if err := user.SetInterest(disc, interest); err != nil {
-				log.Fatalf("Setting interest: %v", err)
-			}
-			return nil
-		})
-		handled = append(handled, user)
-		return nil
-	})
-}
+	log.Printf("Generating interest for %d users in %d discussions (seed %d)",
+		len(users), len(discussions), seed)
 
-const (
-	TestUsers = 8
-	TestDisc  = 6
-	TestSlots = 4
-)
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
 
-func TestPopulate() {
-	panic("Not implemented")
-	// event.Init(EventOptions{
-	// 	AdminPassword: "xenroot"})
-	//SetFlag(FlagTestMode, true)
-	for i := 0; i < TestUsers; i++ {
-		//NewTestUser()
+		err = testSetInterestTx(tx, users, discussions, interest)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
 	}
-	for i := 0; i < TestDisc; i++ {
-		//NewTestDiscussion(nil)
+}
+
+// testSetInterestTx sets the interest of each user in each
+// discussion, all in one transaction, since there may be a lot.
+func testSetInterestTx(tx *sqlx.Tx, users []User, discussions []Discussion, interest [][]int) error {
+	for i := range users {
+		for j := range discussions {
+			if discussions[j].Owner == users[i].UserID {
+				continue
+			}
+			var err error
+			if interest[i][j] == 0 {
+				_, err = tx.Exec(`
+                    delete from event_interest
+                        where userid = ? and discussionid = ?`,
+					users[i].UserID, discussions[j].DiscussionID)
+			} else {
+				err = setInterestTx(tx, users[i].UserID, discussions[j].DiscussionID, interest[i][j])
+			}
+			if err != nil {
+				return err
+			}
+		}
 	}
+	return schedInvalidateTx(tx)
 }
@@ -0,0 +1,96 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testPopulate generates a synthetic event with seed, checks it, and
+// returns everyone's interest, by username and discussion title.
+func testPopulate(t *testing.T, seed int64) (map[string]int, bool) {
+	cleanup, err := LoadTemporary(TestDefaultLocation)
+	if err != nil {
+		t.Errorf("LoadTemporary: %v", err)
+		return nil, true
+	}
+	defer cleanup()
+
+	if err := TestPopulate(TestEventOptions{Users: 12, Discussions: 8, Seed: seed}); err != nil {
+		t.Errorf("TestPopulate: %v", err)
+		return nil, true
+	}
+
+	users, err := UserGetAll()
+	if err != nil || len(users) != 12+1 {
+		t.Errorf("Expected 12 users and admin, got %d (error %v)", len(users), err)
+		return nil, true
+	}
+	slots, err := SlotGetAll()
+	if err != nil || len(slots) != TestDays*(TestSlots+1) {
+		t.Errorf("Expected %d slots, got %d (error %v)", TestDays*(TestSlots+1), len(slots), err)
+		return nil, true
+	}
+	locations, err := LocationGetAll()
+	if err != nil || len(locations) != TestLocations {
+		t.Errorf("Expected %d locations, got %d (error %v)", TestLocations, len(locations), err)
+		return nil, true
+	}
+
+	interest := make(map[string]int)
+	count := 0
+	if err := DiscussionIterate(func(d *Discussion) error {
+		count++
+		if !d.IsPublic {
+			t.Errorf("Discussion %v isn't public", d.DiscussionID)
+		}
+		for i := range users {
+			n, err := users[i].GetInterest(d)
+			if err != nil {
+				return err
+			}
+			interest[users[i].Username+"/"+d.Title] = n
+		}
+		return nil
+	}); err != nil || count != 8 {
+		t.Errorf("Expected 8 discussions, got %d (error %v)", count, err)
+		return nil, true
+	}
+
+	if err := MakeSchedule(SearchOptions{Algo: SearchHeuristicOnly, Validate: true}); err != nil {
+		t.Errorf("MakeSchedule: %v", err)
+		return nil, true
+	}
+	if violations, _, err := ValidateSchedule(); err != nil || len(violations) > 0 {
+		t.Errorf("ValidateSchedule: %v (error %v)", violations, err)
+		return nil, true
+	}
+
+	return interest, false
+}
+
+func testUnitTestPopulate(t *testing.T) (exit bool) {
+	exit = true
+
+	first, subexit := testPopulate(t, 1)
+	if subexit {
+		return
+	}
+	second, subexit := testPopulate(t, 1)
+	if subexit {
+		return
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Same seed generated different interest")
+		return
+	}
+	other, subexit := testPopulate(t, 2)
+	if subexit {
+		return
+	}
+	if reflect.DeepEqual(first, other) {
+		t.Errorf("Different seeds generated the same interest")
+		return
+	}
+
+	return false
+}
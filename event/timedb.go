@@ -43,3 +43,34 @@ func (l *TZLocation) Scan(src interface{}) error {
 func (l TZLocation) Value() (driver.Value, error) {
 	return driver.Value(l.String()), nil
 }
+
+// DBTime is a database-scannable wrapper around time.Time, stored as
+// the output of time.MarshalText()
+type DBTime struct {
+	time.Time
+}
+
+func (t *DBTime) Scan(src interface{}) error {
+	switch ts := src.(type) {
+	case string:
+		return t.UnmarshalText([]byte(ts))
+	case []byte:
+		return t.UnmarshalText(ts)
+	case time.Time:
+		t.Time = ts
+		return nil
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	default:
+		return fmt.Errorf("TimeScan: Cannot convert type %T into Time", src)
+	}
+}
+
+func (t DBTime) Value() (driver.Value, error) {
+	b, err := t.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return driver.Value(string(b)), nil
+}
@@ -1,13 +1,27 @@
 package event
 
+import (
+	"fmt"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+)
+
 type TimetableDiscussion struct {
-	ID        DiscussionID
-	Title     string
-	Attendees int
-	Score     int
+	DiscussionID DiscussionID
+	Title        string
+	Attendees    int
+	Score        int
 	// Copy of the "canonical" location, updated every time the
 	// schedule is run
 	LocationInfo Location
+	// More people are expected to attend than the location holds
+	IsOverflow bool
+	// Number of consecutive slots the discussion takes
+	Length int
+	// The discussion started in an earlier slot, at StartTime
+	IsContinuation bool
+	StartTime      string
 }
 
 type TimetableSlot struct {
@@ -31,12 +45,285 @@ type Timetable struct {
 	Days []TimetableDay
 }
 
+func formatSlotTime(t DBTime) string {
+	return t.In(event.defaultLocation).Format("15:04")
+}
+
+// timetableGetSlots returns all non-break slots, checked if the SQL
+// expression checked is true for them.  args are the arguments for
+// checked, and desc describes the slots in errors.
+func timetableGetSlots(desc, checked string, args ...interface{}) []DisplaySlot {
+	var slots []struct {
+		SlotID   SlotID
+		DayName  string
+		SlotTime DBTime
+		Checked  bool
+	}
+	for {
+		err := event.Select(&slots, `
+            select slotid, dayname, slottime, `+checked+` as checked
+                from event_slots natural join event_days
+                where isbreak = false
+                order by dayid, slotidx`, args...)
+		if shouldRetry(err) {
+			continue
+		} else if err != nil {
+			log.Printf("INTERNAL ERROR: Getting %s: %v", desc, err)
+			return nil
+		}
+		break
+	}
+
+	ds := make([]DisplaySlot, len(slots))
+	for i, slot := range slots {
+		ds[i] = DisplaySlot{
+			Label:   slot.DayName + " " + formatSlotTime(slot.SlotTime),
+			SlotID:  slot.SlotID,
+			Checked: slot.Checked,
+		}
+	}
+	return ds
+}
+
+// TimetableGetLockedSlots returns all non-break slots, checked if
+// they're locked.
 func TimetableGetLockedSlots() []DisplaySlot {
-	// FIXME: Timetable
-	return nil
+	return timetableGetSlots("locked slots", "islocked")
+}
+
+// TimetableGetPossibleSlots returns all non-break slots, checked if
+// discussion did may be scheduled in them.  A discussion with no
+// possible slots listed may be scheduled in any slot.
+func TimetableGetPossibleSlots(did DiscussionID) []DisplaySlot {
+	return timetableGetSlots(fmt.Sprintf("possible slots for %v", did), `
+                   (not exists (select 1 from event_discussions_possible_slots as p
+                                    where p.discussionid = ?)
+                    or exists (select 1 from event_discussions_possible_slots as p
+                                   where p.discussionid = ?
+                                     and p.slotid = event_slots.slotid))`, did, did)
+}
+
+// TimetableGetPinSlots returns all non-break slots, checked if
+// discussion did is pinned to start in them.
+func TimetableGetPinSlots(did DiscussionID) []DisplaySlot {
+	return timetableGetSlots(fmt.Sprintf("pin slots for %v", did), `
+                   exists (select 1 from event_discussion_pins as p
+                               where p.discussionid = ?
+                                 and p.slotid = event_slots.slotid)`, did)
+}
+
+// TimetableGetLockedDays returns all days, checked if all their
+// non-break slots are locked.
+func TimetableGetLockedDays() []DisplayDay {
+	var days []struct {
+		DayID    DayID
+		DayName  string
+		Unlocked int
+	}
+	for {
+		err := event.Select(&days, `
+            select dayid, dayname,
+                   (select count(*) from event_slots
+                        where event_slots.dayid = event_days.dayid
+                          and isbreak = false and islocked = false) as unlocked
+                from event_days
+                order by dayid`)
+		if shouldRetry(err) {
+			continue
+		} else if err != nil {
+			log.Printf("INTERNAL ERROR: Getting locked days: %v", err)
+			return nil
+		}
+		break
+	}
+
+	dd := make([]DisplayDay, len(days))
+	for i, day := range days {
+		dd[i] = DisplayDay{
+			Label:   day.DayName,
+			DayID:   day.DayID,
+			Checked: day.Unlocked == 0,
+		}
+	}
+	return dd
+}
+
+// TimetableGetUnavailableSlots returns all non-break slots, checked if
+// user uid isn't available during them.
+func TimetableGetUnavailableSlots(uid UserID) []DisplaySlot {
+	return timetableGetSlots(fmt.Sprintf("unavailable slots for %v", uid), `
+                   exists (select 1 from event_users_unavailable_slots as u
+                               where u.userid = ?
+                                 and u.slotid = event_slots.slotid)`, uid)
+}
+
+// TimetableGetUnavailableDays returns all days, checked if user uid
+// isn't available for any of their non-break slots.
+func TimetableGetUnavailableDays(uid UserID) []DisplayDay {
+	var days []struct {
+		DayID     DayID
+		DayName   string
+		Available int
+	}
+	for {
+		err := event.Select(&days, `
+            select dayid, dayname,
+                   (select count(*) from event_slots
+                        where event_slots.dayid = event_days.dayid
+                          and isbreak = false
+                          and slotid not in (select slotid from event_users_unavailable_slots
+                                                 where userid = ?)) as available
+                from event_days
+                order by dayid`, uid)
+		if shouldRetry(err) {
+			continue
+		} else if err != nil {
+			log.Printf("INTERNAL ERROR: Getting unavailable days for %v: %v", uid, err)
+			return nil
+		}
+		break
+	}
+
+	dd := make([]DisplayDay, len(days))
+	for i, day := range days {
+		dd[i] = DisplayDay{
+			Label:   day.DayName,
+			DayID:   day.DayID,
+			Checked: day.Available == 0,
+		}
+	}
+	return dd
+}
+
+func getTimetableTx(q sqlx.Queryer) (tt Timetable, err error) {
+	data, err := loadSearchDataTx(q)
+	if err != nil {
+		return
+	}
+
+	entries, err := loadScheduleEntriesTx(q)
+	if err != nil {
+		return
+	}
+
+	var days []Day
+	if err = sqlx.Select(q, &days, `select * from event_days order by dayid`); err != nil {
+		return
+	}
+
+	var slots []Slot
+	if err = sqlx.Select(q, &slots,
+		`select * from event_slots order by dayid, slotidx`); err != nil {
+		return
+	}
+
+	var locations []Location
+	if err = sqlx.Select(q, &locations,
+		`select * from event_locations order by locationid`); err != nil {
+		return
+	}
+	locationMap := make(map[LocationID]Location, len(locations))
+	for _, loc := range locations {
+		locationMap[loc.LocationID] = loc
+	}
+
+	slotTimes := make(map[SlotID]string, len(slots))
+	for _, slot := range slots {
+		slotTimes[slot.SlotID] = formatSlotTime(slot.SlotTime)
+	}
+
+	attendees, score := data.scheduleFromEntries(entries).attendance()
+	discIdx := data.discussionIndex()
+
+	// Entries for each discussion are in slot order, so the first is
+	// where it starts
+	bySlot := make(map[SlotID][]TimetableDiscussion)
+	start := make(map[DiscussionID]SlotID)
+	for _, e := range entries {
+		d, prs := discIdx[e.DiscussionID]
+		if !prs {
+			continue
+		}
+		first, started := start[e.DiscussionID]
+		if !started {
+			first = e.SlotID
+			start[e.DiscussionID] = first
+		}
+		bySlot[e.SlotID] = append(bySlot[e.SlotID], TimetableDiscussion{
+			DiscussionID:   e.DiscussionID,
+			Title:          data.discussions[d].title,
+			Attendees:      attendees[d],
+			Score:          score[d],
+			LocationInfo:   locationMap[e.LocationID],
+			IsOverflow:     attendees[d] > locationMap[e.LocationID].Capacity,
+			Length:         data.discussions[d].length,
+			IsContinuation: started,
+			StartTime:      slotTimes[first],
+		})
+	}
+
+	dayIdx := make(map[DayID]int, len(days))
+	tt.Days = make([]TimetableDay, len(days))
+	for i, day := range days {
+		dayIdx[day.DayID] = i
+		tt.Days[i].DayName = day.DayName
+		tt.Days[i].IsFinal = true
+	}
+
+	for _, slot := range slots {
+		i, prs := dayIdx[slot.DayID]
+		if !prs {
+			return tt, fmt.Errorf("Slot %v has unknown day %v", slot.SlotID, slot.DayID)
+		}
+		day := &tt.Days[i]
+		if !slot.IsLocked && !slot.IsBreak {
+			day.IsFinal = false
+		}
+		day.Slots = append(day.Slots, TimetableSlot{
+			Time:        formatSlotTime(slot.SlotTime),
+			IsBreak:     slot.IsBreak,
+			Discussions: bySlot[slot.SlotID],
+		})
+	}
+
+	return tt, nil
+}
+
+// Overflows returns all discussions which are expected to have more
+// attendees than their location holds.
+func (tt *Timetable) Overflows() (overflows []TimetableDiscussion) {
+	for _, day := range tt.Days {
+		for _, slot := range day.Slots {
+			for _, disc := range slot.Discussions {
+				if disc.IsOverflow && !disc.IsContinuation {
+					overflows = append(overflows, disc)
+				}
+			}
+		}
+	}
+	return
 }
 
 func GetTimetable() Timetable {
-	// FIXME: Timetable
-	return Timetable{}
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			log.Printf("INTERNAL ERROR: Starting transaction: %v", err)
+			return Timetable{}
+		}
+		defer tx.Rollback()
+
+		tt, err := getTimetableTx(tx)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			log.Printf("INTERNAL ERROR: Getting timetable: %v", err)
+		}
+
+		return tt
+	}
 }
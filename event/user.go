@@ -11,8 +11,11 @@ import (
 	"github.com/gwd/session-scheduler/id"
 )
 
+// hashCost is the bcrypt cost of password hashes.  Tests lower it, as
+// they create a lot of users.
+var hashCost = 10
+
 const (
-	hashCost       = 10
 	passwordLength = 6
 	userIDLength   = 16
 	InterestMax    = 100
@@ -148,9 +151,10 @@ func (user *User) SetInterest(disc *Discussion, interest int) error {
 				continue
 			case err == sql.ErrNoRows:
 				return nil
-			default:
-				return err
+			case err == nil:
+				schedInvalidate()
 			}
+			return err
 		}
 	default:
 		for {
@@ -160,9 +164,10 @@ func (user *User) SetInterest(disc *Discussion, interest int) error {
 				continue
 			case isErrorForeignKey(err):
 				return ErrUserOrDiscussionNotFound
-			default:
-				return err
+			case err == nil:
+				schedInvalidate()
 			}
+			return err
 		}
 	}
 }
@@ -196,6 +201,30 @@ func (user *User) GetInterest(disc *Discussion) (int, error) {
 	}
 }
 
+// GetMaxScore returns the maximum possible score a user could have
+// if they could attend every discussion; that is, the sum of all the
+// interests they've expressed in public discussions.
+func (user *User) GetMaxScore() (int, error) {
+	var maxscore int
+	for {
+		err := event.Get(&maxscore, `
+            select IFNULL(sum(interest), 0)
+                from event_interest natural join event_discussions
+                where userid = ? and ispublic = true`,
+			user.UserID)
+		switch {
+		case shouldRetry(err):
+			continue
+		case err != nil:
+			log.Printf("INTERNAL ERROR: Getting max score for user %v: %v",
+				user.UserID, err)
+			return 0, err
+		default:
+			return maxscore, err
+		}
+	}
+}
+
 func passwordHash(newPassword string) (string, error) {
 	hashedPasswordBytes, err := bcrypt.GenerateFromPassword([]byte(newPassword), hashCost)
 	return string(hashedPasswordBytes), err
@@ -365,6 +394,86 @@ func DeleteUser(userid UserID) error {
 				userid, err)
 		}
 
+		// Remove any discussions owned by this user from the schedule
+		_, err = tx.Exec(`
+           delete from event_schedule
+               where discussionid in (
+                   select discussionid
+                       from event_discussions
+                       where owner = ?)`, userid)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Deleting discussions owned by %v from schedule: %v",
+				userid, err)
+		}
+
+		_, err = tx.Exec(`
+           delete from event_discussions_possible_slots
+               where discussionid in (
+                   select discussionid
+                       from event_discussions
+                       where owner = ?)`, userid)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Deleting possible slots of discussions owned by %v: %v",
+				userid, err)
+		}
+
+		_, err = tx.Exec(`
+           delete from event_discussion_tags
+               where discussionid in (
+                   select discussionid
+                       from event_discussions
+                       where owner = ?)`, userid)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Deleting tags of discussions owned by %v: %v",
+				userid, err)
+		}
+
+		_, err = tx.Exec(`
+           delete from event_discussion_pins
+               where discussionid in (
+                   select discussionid
+                       from event_discussions
+                       where owner = ?)`, userid)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Deleting pins of discussions owned by %v: %v",
+				userid, err)
+		}
+
+		_, err = tx.Exec(`
+           delete from event_discussion_orders
+               where beforeid in (select discussionid from event_discussions where owner = ?)
+                  or afterid in (select discussionid from event_discussions where owner = ?)`,
+			userid, userid)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Deleting orders of discussions owned by %v: %v",
+				userid, err)
+		}
+
+		_, err = tx.Exec(`
+           delete from event_users_unavailable_slots
+               where userid = ?`, userid)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Deleting unavailable slots of %v: %v", userid, err)
+		}
+
 		// And delete any discussions owned by this user
 		_, err = tx.Exec(`
         delete from event_discussions
@@ -400,6 +509,14 @@ func DeleteUser(userid UserID) error {
 			return ErrInternal
 		}
 
+		err = schedInvalidateTx(tx)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Invalidating schedule: %v", err)
+		}
+
 		err = tx.Commit()
 		if shouldRetry(err) {
 			tx.Rollback()
@@ -524,3 +641,90 @@ func UserGetAll() (users []User, err error) {
 		}
 	}
 }
+
+// UserSetUnavailable records that user uid isn't at the event during
+// the listed slots, nor during any of the listed days, replacing what
+// was recorded before.  The scheduler won't put the user's
+// discussions in those slots, nor count their interest there.
+func UserSetUnavailable(uid UserID, slots []SlotID, days []DayID) error {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		err = userSetUnavailableTx(tx, uid, slots, days)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+func userSetUnavailableTx(tx *sqlx.Tx, uid UserID, slots []SlotID, days []DayID) error {
+	var count int
+	err := tx.Get(&count, `select count(*) from event_users where userid = ?`, uid)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrUserNotFound
+	}
+
+	_, err = tx.Exec(`delete from event_users_unavailable_slots where userid = ?`, uid)
+	if err != nil {
+		return err
+	}
+
+	for _, sid := range slots {
+		err = tx.Get(&count, `
+            select count(*) from event_slots where slotid = ? and isbreak = false`, sid)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrSlotNotFound
+		}
+		_, err = tx.Exec(`
+            insert or ignore into event_users_unavailable_slots(userid, slotid)
+                values(?, ?)`, uid, sid)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, did := range days {
+		err = tx.Get(&count, `select count(*) from event_days where dayid = ?`, did)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrDayNotFound
+		}
+		_, err = tx.Exec(`
+            insert or ignore into event_users_unavailable_slots(userid, slotid)
+                select ?, slotid from event_slots
+                    where dayid = ? and isbreak = false`, uid, did)
+		if err != nil {
+			return err
+		}
+	}
+
+	return schedInvalidateTx(tx)
+}
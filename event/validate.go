@@ -0,0 +1,401 @@
+package event
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// violations checks the schedule against the hard constraints,
+// returning a description of each violation found.
+func (s *schedule) violations() []string {
+	var v []string
+
+	for d, slot := range s.slotOf {
+		sd := &s.data.discussions[d]
+		if locked := sd.lockedSlot; locked >= 0 {
+			if slot != locked && sd.pinned {
+				v = append(v, fmt.Sprintf("Discussion %v moved away from slot %v, where it's pinned",
+					sd.id, s.data.slots[locked].id))
+			} else if slot != locked {
+				v = append(v, fmt.Sprintf("Discussion %v moved out of locked slot %v",
+					sd.id, s.data.slots[locked].id))
+			}
+			continue
+		}
+		if slot < 0 {
+			continue
+		}
+		if !s.data.fits(d, slot) {
+			v = append(v, fmt.Sprintf("Discussion %v takes %d slots, which don't fit from slot %v",
+				sd.id, sd.length, s.data.slots[slot].id))
+			continue
+		}
+		for k := slot; k < slot+sd.length; k++ {
+			if s.data.slots[k].locked {
+				v = append(v, fmt.Sprintf("Discussion %v added to locked slot %v",
+					sd.id, s.data.slots[k].id))
+			} else if !sd.mayUse(k) {
+				v = append(v, fmt.Sprintf("Discussion %v is in slot %v, which isn't one of its possible slots",
+					sd.id, s.data.slots[k].id))
+			} else if !s.data.available(sd.owner, k) {
+				v = append(v, fmt.Sprintf("Discussion %v is in slot %v, when its owner %v is unavailable",
+					sd.id, s.data.slots[k].id, s.data.users[sd.owner]))
+			}
+		}
+	}
+
+	// Discussions which are both locked or pinned can't have been put
+	// in order by the search
+	for d, slot := range s.slotOf {
+		if slot < 0 {
+			continue
+		}
+		for _, o := range s.data.discussions[d].precedes {
+			other := s.slotOf[o.other]
+			if other < 0 || s.data.inOrder(d, slot, o.other, other, o.sameDay) ||
+				(!s.movable(d) && !s.movable(o.other)) {
+				continue
+			}
+			if !s.data.inOrder(d, slot, o.other, other, false) {
+				v = append(v, fmt.Sprintf("Discussion %v must end before %v starts",
+					s.data.discussions[d].id, s.data.discussions[o.other].id))
+			} else {
+				v = append(v, fmt.Sprintf("Discussion %v must be on the same day as %v",
+					s.data.discussions[d].id, s.data.discussions[o.other].id))
+			}
+		}
+	}
+
+	for slot, discs := range s.bySlot() {
+		if len(discs) > len(s.data.locations) {
+			v = append(v, fmt.Sprintf("Slot %v has %d discussions but only %d locations",
+				s.data.slots[slot].id, len(discs), len(s.data.locations)))
+		}
+
+		owners := make(map[int]int)
+		for _, d := range discs {
+			owner := s.data.discussions[d].owner
+			if od, prs := owners[owner]; prs {
+				v = append(v, fmt.Sprintf("Owner %v has discussions %v and %v both in slot %v",
+					s.data.users[owner], s.data.discussions[od].id,
+					s.data.discussions[d].id, s.data.slots[slot].id))
+			} else {
+				owners[owner] = d
+			}
+		}
+	}
+
+	return v
+}
+
+// validate returns an error describing all hard constraint
+// violations in the schedule, or nil if there are none.
+func (s *schedule) validate() error {
+	v := s.violations()
+	if len(v) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d constraint violations: %s", len(v), strings.Join(v, "; "))
+}
+
+// validateScheduleTx checks the stored schedule against every
+// invariant:
+// - Each (slot, location) has at most one discussion
+// - Each discussion takes as many consecutive slots as its length, in one location
+// - No discussions are in breaks, or in locations which aren't places
+// - Every day has slots, with slotidx contiguous starting at 1
+// - Discussions are only in their possible slots, if they have any
+// - No discussions are in slots during which their owner is unavailable
+// - No owner has two discussions in the same slot
+// - Pinned discussions are where they're pinned
+// - Discussions which must come before others end before they start, on the same day if required
+// - No discussion is expected to have more attendees than its location holds
+// - If s is the schedule just stored, every discussion it schedules is stored where it starts
+//
+// Pinned discussions are exempt from their possible slots and their
+// owner's availability, since an admin has put them there.
+//
+// Discussions from the same track in the same slot are returned as
+// warnings rather than violations, since the search only tries to
+// avoid them.
+func validateScheduleTx(q sqlx.Queryer, s *schedule) (violations, warnings []string, err error) {
+	var doubled []struct {
+		SlotID     SlotID
+		LocationID LocationID
+		Count      int
+	}
+	if err = sqlx.Select(q, &doubled, `
+        select slotid, locationid, count(*) as count
+            from event_schedule
+            group by slotid, locationid
+            having count(*) > 1`); err != nil {
+		return
+	}
+	for _, d := range doubled {
+		violations = append(violations, fmt.Sprintf("Slot %v location %v has %d discussions",
+			d.SlotID, d.LocationID, d.Count))
+	}
+
+	var spans []struct {
+		DiscussionID DiscussionID
+		Length       int
+		DayID        DayID
+		SlotIdx      int
+		LocationID   LocationID
+	}
+	if err = sqlx.Select(q, &spans, `
+        select discussionid, length, dayid, slotidx, locationid
+            from event_schedule
+                natural join event_discussions
+                natural join event_slots
+            order by discussionid, dayid, slotidx`); err != nil {
+		return
+	}
+	// Indexes into spans of each discussion's first and last slot
+	starts := make(map[DiscussionID]int)
+	ends := make(map[DiscussionID]int)
+	for i := 0; i < len(spans); {
+		first := spans[i]
+		j := i + 1
+		consecutive := true
+		for ; j < len(spans) && spans[j].DiscussionID == first.DiscussionID; j++ {
+			if spans[j].DayID != first.DayID || spans[j].SlotIdx != first.SlotIdx+j-i ||
+				spans[j].LocationID != first.LocationID {
+				consecutive = false
+			}
+		}
+		if j-i != first.Length {
+			violations = append(violations, fmt.Sprintf("Discussion %v is in %d slots, but takes %d",
+				first.DiscussionID, j-i, first.Length))
+		} else if !consecutive {
+			violations = append(violations, fmt.Sprintf("Discussion %v isn't in consecutive slots in one location",
+				first.DiscussionID))
+		}
+		starts[first.DiscussionID] = i
+		ends[first.DiscussionID] = j - 1
+		i = j
+	}
+
+	if s != nil {
+		for d, slot := range s.slotOf {
+			if slot < 0 {
+				continue
+			}
+			sd := &s.data.discussions[d]
+			if i, prs := starts[sd.id]; !prs || spans[i].DayID != s.data.slots[slot].day ||
+				spans[i].SlotIdx != s.data.slots[slot].idx {
+				violations = append(violations, fmt.Sprintf("Discussion %v was scheduled in slot %v, but isn't stored there",
+					sd.id, s.data.slots[slot].id))
+			}
+		}
+	}
+
+	var orders []DiscussionOrder
+	if err = sqlx.Select(q, &orders, `
+        select beforeid, afterid, sameday
+            from event_discussion_orders
+            order by beforeid, afterid`); err != nil {
+		return
+	}
+	for _, o := range orders {
+		b, bprs := ends[o.BeforeID]
+		a, aprs := starts[o.AfterID]
+		if !bprs || !aprs {
+			continue
+		}
+		end, start := spans[b], spans[a]
+		if end.DayID > start.DayID || (end.DayID == start.DayID && end.SlotIdx >= start.SlotIdx) {
+			violations = append(violations, fmt.Sprintf("Discussion %v must end before %v starts",
+				o.BeforeID, o.AfterID))
+		} else if o.SameDay && end.DayID != start.DayID {
+			violations = append(violations, fmt.Sprintf("Discussion %v must be on the same day as %v",
+				o.BeforeID, o.AfterID))
+		}
+	}
+
+	var misplaced []struct {
+		DiscussionID DiscussionID
+		SlotID       SlotID
+		LocationID   LocationID
+		IsBreak      bool
+		IsPlace      bool
+	}
+	if err = sqlx.Select(q, &misplaced, `
+        select discussionid, slotid, locationid, isbreak, isplace
+            from event_schedule
+                natural join event_slots
+                natural join event_locations
+            where isbreak = true or isplace = false`); err != nil {
+		return
+	}
+	for _, m := range misplaced {
+		if m.IsBreak {
+			violations = append(violations, fmt.Sprintf("Discussion %v is in break %v",
+				m.DiscussionID, m.SlotID))
+		}
+		if !m.IsPlace {
+			violations = append(violations, fmt.Sprintf("Discussion %v is in location %v, which isn't a place",
+				m.DiscussionID, m.LocationID))
+		}
+	}
+
+	var days []struct {
+		DayID   DayID
+		SlotIdx int
+	}
+	if err = sqlx.Select(q, &days, `
+        select dayid, coalesce(slotidx, 0) as slotidx
+            from event_days natural left join event_slots
+            order by dayid, slotidx`); err != nil {
+		return
+	}
+	expected := 1
+	for i, d := range days {
+		if i > 0 && days[i-1].DayID != d.DayID {
+			expected = 1
+		}
+		switch {
+		case d.SlotIdx == 0:
+			violations = append(violations, fmt.Sprintf("Day %v has no slots", d.DayID))
+		case d.SlotIdx != expected:
+			violations = append(violations, fmt.Sprintf("Day %v: expected slot %d, found slot %d",
+				d.DayID, expected, d.SlotIdx))
+			expected = d.SlotIdx
+		}
+		expected++
+	}
+
+	var impossible []struct {
+		DiscussionID DiscussionID
+		SlotID       SlotID
+	}
+	if err = sqlx.Select(q, &impossible, `
+        select discussionid, slotid
+            from event_schedule as s
+            where discussionid not in (select discussionid from event_discussion_pins)
+              and exists (select 1 from event_discussions_possible_slots as p
+                              where p.discussionid = s.discussionid)
+              and not exists (select 1 from event_discussions_possible_slots as p
+                                  where p.discussionid = s.discussionid
+                                    and p.slotid = s.slotid)`); err != nil {
+		return
+	}
+	for _, i := range impossible {
+		violations = append(violations, fmt.Sprintf("Discussion %v is in slot %v, which isn't one of its possible slots",
+			i.DiscussionID, i.SlotID))
+	}
+
+	var away []struct {
+		DiscussionID DiscussionID
+		Owner        UserID
+		SlotID       SlotID
+	}
+	if err = sqlx.Select(q, &away, `
+        select discussionid, owner, event_schedule.slotid as slotid
+            from event_schedule natural join event_discussions
+              join event_users_unavailable_slots as u
+                on u.userid = owner and u.slotid = event_schedule.slotid
+            where discussionid not in (select discussionid from event_discussion_pins)`); err != nil {
+		return
+	}
+	for _, a := range away {
+		violations = append(violations, fmt.Sprintf("Discussion %v is in slot %v, when its owner %v is unavailable",
+			a.DiscussionID, a.SlotID, a.Owner))
+	}
+
+	var busy []struct {
+		Owner  UserID
+		SlotID SlotID
+		Count  int
+	}
+	if err = sqlx.Select(q, &busy, `
+        select owner, slotid, count(*) as count
+            from event_schedule natural join event_discussions
+            group by owner, slotid
+            having count(*) > 1`); err != nil {
+		return
+	}
+	for _, b := range busy {
+		violations = append(violations, fmt.Sprintf("Owner %v has %d discussions in slot %v",
+			b.Owner, b.Count, b.SlotID))
+	}
+
+	var unpinned []DiscussionPin
+	if err = sqlx.Select(q, &unpinned, `
+        select p.discussionid, p.slotid, p.locationid
+            from event_discussion_pins as p
+                join event_discussions as d on d.discussionid = p.discussionid
+            where d.ispublic = true
+              and not exists (select 1 from event_schedule as e
+                                  where e.discussionid = p.discussionid
+                                    and e.slotid = p.slotid
+                                    and e.locationid = p.locationid)`); err != nil {
+		return
+	}
+	for _, p := range unpinned {
+		violations = append(violations, fmt.Sprintf("Discussion %v is pinned to slot %v location %v, but isn't there",
+			p.DiscussionID, p.SlotID, p.LocationID))
+	}
+
+	var overlaps []struct {
+		First  DiscussionID
+		Second DiscussionID
+		Tag    string
+		SlotID SlotID
+	}
+	if err = sqlx.Select(q, &overlaps, `
+        select a.discussionid as first, b.discussionid as second, ta.tag as tag, a.slotid as slotid
+            from event_schedule as a
+              join event_schedule as b
+                on b.slotid = a.slotid and b.discussionid > a.discussionid
+              join event_discussion_tags as ta on ta.discussionid = a.discussionid
+              join event_discussion_tags as tb
+                on tb.discussionid = b.discussionid and tb.tag = ta.tag
+              left join event_tracks as t on t.tag = ta.tag
+            where coalesce(t.avoidoverlap, ?)
+            order by a.slotid, ta.tag`, defaultTrack.AvoidOverlap); err != nil {
+		return
+	}
+	for _, o := range overlaps {
+		warnings = append(warnings, fmt.Sprintf("Discussions %v and %v, both in track %s, are in slot %v",
+			o.First, o.Second, o.Tag, o.SlotID))
+	}
+
+	tt, err := getTimetableTx(q)
+	if err != nil {
+		return
+	}
+	for _, disc := range tt.Overflows() {
+		violations = append(violations, fmt.Sprintf("Discussion %v expects %d attendees, but %s only holds %d",
+			disc.DiscussionID, disc.Attendees, disc.LocationInfo.LocationName,
+			disc.LocationInfo.Capacity))
+	}
+
+	return
+}
+
+// ValidateSchedule checks the stored schedule; see validateScheduleTx.
+func ValidateSchedule() (violations, warnings []string, err error) {
+	for {
+		tx, err := event.Beginx()
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("Starting transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		violations, warnings, err = validateScheduleTx(tx, nil)
+		if shouldRetry(err) {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("Validating schedule: %v", err)
+		}
+
+		return violations, warnings, nil
+	}
+}
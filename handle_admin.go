@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/julienschmidt/httprouter"
 
@@ -34,12 +36,42 @@ func HandleAdminConsole(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		switch event.SchedGetState() {
 		case event.SchedStateRunning:
 			content["IsInProgress"] = true
+			if progress, ok := event.SchedGetProgress(); ok {
+				content["Progress"] = progress
+			}
 		case event.SchedStateModified:
 			content["IsStale"] = true
 		default:
 			content["IsCurrent"] = true
 		}
-		//content["LockedSlots"] = event.TimetableGetLockedSlots()
+		tt := event.GetTimetable()
+		content["Overflows"] = tt.Overflows()
+		if dist, err := event.UtilityDistributionGet(); err != nil {
+			log.Printf("INTERNAL ERROR: Getting utility distribution: %v", err)
+		} else {
+			content["Utility"] = dist
+		}
+		content["LockedSlots"] = event.TimetableGetLockedSlots()
+		content["LockedDays"] = event.TimetableGetLockedDays()
+		if tracks, err := event.TrackGetAll(); err != nil {
+			log.Printf("INTERNAL ERROR: Getting tracks: %v", err)
+		} else {
+			content["Tracks"] = tracks
+		}
+		if versions, err := event.ScheduleVersionGetAll(); err != nil {
+			log.Printf("INTERNAL ERROR: Getting schedule versions: %v", err)
+		} else {
+			content["Versions"] = versions
+		}
+		if from, err := strconv.Atoi(r.FormValue("difffrom")); err == nil {
+			if to, err := strconv.Atoi(r.FormValue("diffto")); err == nil {
+				if diff, err := event.ScheduleVersionDiff(from, to); err != nil {
+					log.Printf("Diffing schedule versions %d and %d: %v", from, to, err)
+				} else {
+					content["Diff"] = diff
+				}
+			}
+		}
 		fallthrough
 	case "test":
 		content[tmpl] = true
@@ -49,6 +81,7 @@ func HandleAdminConsole(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 }
 
 var OptSearchAlgo string
+var OptSearchSeed int64
 
 func HandleAdminAction(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	user := RequestUser(r)
@@ -59,10 +92,14 @@ func HandleAdminAction(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 
 	action := ps.ByName("action")
 	if !(action == "runschedule" ||
+		action == "cancelschedule" ||
+		action == "validateschedule" ||
+		action == "rollbackschedule" ||
 		action == "setvcode" ||
 		action == "setstatus" ||
 		action == "resetEventData" ||
-		action == "setLocked") {
+		action == "setLocked" ||
+		action == "setTracks") {
 		return
 	}
 
@@ -75,6 +112,46 @@ func HandleAdminAction(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 			log.Printf("Error generating schedule: %v", err)
 			http.Redirect(w, r, "console?flash=Error+starting+schedule: See Log", http.StatusFound)
 		}
+	case "cancelschedule":
+		flash := "Schedule+Cancelled"
+		if err := event.SchedCancel(); err != nil {
+			log.Printf("Error cancelling schedule: %v", err)
+			flash = "Error+cancelling+schedule: See Log"
+		}
+		http.Redirect(w, r, "console?flash="+flash, http.StatusFound)
+	case "validateschedule":
+		violations, warnings, err := event.ValidateSchedule()
+		var flash string
+		switch {
+		case err != nil:
+			log.Printf("Error validating schedule: %v", err)
+			flash = "Error+validating+schedule: See Log"
+		case len(violations) > 0:
+			flash = fmt.Sprintf("Schedule+has+%d+violations+and+%d+warnings: See Log",
+				len(violations), len(warnings))
+		case len(warnings) > 0:
+			flash = fmt.Sprintf("Schedule+valid,+with+%d+warnings: See Log", len(warnings))
+		default:
+			flash = "Schedule+valid"
+		}
+		for _, v := range violations {
+			log.Printf("Schedule violation: %s", v)
+		}
+		for _, w := range warnings {
+			log.Printf("Schedule warning: %s", w)
+		}
+		http.Redirect(w, r, "console?flash="+flash, http.StatusFound)
+	case "rollbackschedule":
+		flash := "Schedule+rolled+back"
+		version, err := strconv.Atoi(r.FormValue("version"))
+		if err == nil {
+			err = event.ScheduleRollback(version)
+		}
+		if err != nil {
+			log.Printf("Error rolling back schedule to version %s: %v", r.FormValue("version"), err)
+			flash = "Error+rolling+back+schedule: See Log"
+		}
+		http.Redirect(w, r, "console?flash="+flash, http.StatusFound)
 	case "setvcode":
 		newvcode := r.FormValue("vcode")
 		if newvcode == "" {
@@ -160,12 +237,44 @@ func HandleAdminAction(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 		r.ParseForm()
 		locked, err := FormCheckToBool(r.Form["locked"])
 		if err != nil {
+			log.Printf("Parsing locked slots: %v", err)
+			return
+		}
+		days, err := FormCheckToDays(r.Form["lockedday"])
+		if err != nil {
+			log.Printf("Parsing locked days: %v", err)
 			return
 		}
-		log.Printf("New locked slots: %v", locked)
-		// FIXME: LockedSlots.  We'll need to pass in slot ids now.
-		//event.LockedSlotsSet(locked)
-		http.Redirect(w, r, "console?flash=Locked+slots+updated", http.StatusFound)
+		log.Printf("New locked slots: %v, days: %v", locked, days)
+		flash := "Locked+slots+updated"
+		if err := event.LockedSlotsSet(locked, days); err != nil {
+			log.Printf("Error setting locked slots: %v", err)
+			flash = "Error+setting+locked+slots: See Log"
+		}
+		http.Redirect(w, r, "console?flash="+flash, http.StatusFound)
+	case "setTracks":
+		r.ParseForm()
+		avoidOverlap := make(map[string]bool)
+		for _, tag := range r.Form["avoidoverlap"] {
+			avoidOverlap[tag] = true
+		}
+		sameRoom := make(map[string]bool)
+		for _, tag := range r.Form["sameroom"] {
+			sameRoom[tag] = true
+		}
+		var tracks []event.Track
+		for _, tag := range r.Form["track"] {
+			tracks = append(tracks, event.Track{
+				Tag:          tag,
+				AvoidOverlap: avoidOverlap[tag],
+				SameRoom:     sameRoom[tag]})
+		}
+		flash := "Tracks+updated"
+		if err := event.TracksSet(tracks); err != nil {
+			log.Printf("Error setting tracks: %v", err)
+			flash = "Error+setting+tracks: See Log"
+		}
+		http.Redirect(w, r, "console?flash="+flash, http.StatusFound)
 	}
 }
 
@@ -223,7 +332,7 @@ func HandleTestAction(w http.ResponseWriter, r *http.Request, ps httprouter.Para
 		// 		flash = countString + " discussions generated"
 		// 	}
 		// case "geninterest":
-		// 	event.TestGenerateInterest()
+		// 	event.TestGenerateInterest(time.Now().UnixNano())
 		// 	flash = "Interest generated"
 		default:
 			return
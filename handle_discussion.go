@@ -1,9 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"log"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strconv"
 
@@ -19,7 +21,9 @@ func HandleDiscussionNew(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 		}
 	}
 
-	RenderTemplate(w, r, "discussion/new", nil)
+	RenderTemplate(w, r, "discussion/new", map[string]interface{}{
+		"Discussion": &DiscussionDisplay{Length: 1, Lengths: discussionLengths()},
+	})
 }
 
 func HandleDiscussionNotFound(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -38,7 +42,21 @@ func HandleDiscussionCreate(w http.ResponseWriter, r *http.Request, _ httprouter
 		Owner:       owner.UserID,
 		Title:       r.FormValue("title"),
 		Description: r.FormValue("description")}
-	err := event.NewDiscussion(&d)
+
+	length, err := FormLength(r.FormValue("length"))
+	if err != nil {
+		log.Printf("Parsing length: %v", err)
+		return
+	}
+	d.Length = length
+
+	tags, err := event.TagsParse(r.FormValue("tags"))
+	if err == nil {
+		err = event.NewDiscussion(&d)
+	}
+	if err == nil && len(tags) > 0 {
+		err = event.DiscussionSetTags(d.DiscussionID, tags)
+	}
 
 	if err != nil {
 		if event.IsValidationError(err) {
@@ -164,9 +182,38 @@ func HandleUid(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	})
 }
 
-func FormCheckToBool(formData []string) (bslot []bool, err error) {
-	// FIXME: Locked Slots
-	return nil, nil
+// FormCheckToBool converts the values of a list of slot checkboxes
+// into the SlotIDs of the checked slots.
+func FormCheckToBool(formData []string) (slots []event.SlotID, err error) {
+	for _, s := range formData {
+		if s == "" {
+			return nil, fmt.Errorf("Empty slot id")
+		}
+		slots = append(slots, event.SlotID(s))
+	}
+	return slots, nil
+}
+
+// FormLength converts the value of the length select into a number of
+// slots.  An empty value means a single slot.
+func FormLength(s string) (int, error) {
+	if s == "" {
+		return 1, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// FormCheckToDays converts the values of a list of day checkboxes
+// into the DayIDs of the checked days.
+func FormCheckToDays(formData []string) (days []event.DayID, err error) {
+	for _, s := range formData {
+		did, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid day id %q: %v", s, err)
+		}
+		days = append(days, event.DayID(did))
+	}
+	return days, nil
 }
 
 func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -191,7 +238,8 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	//log.Printf("POST %s %s %s", uid, action, itype)
 
 	if !((itype == "discussion" &&
-		(action == "setinterest" || action == "edit" || action == "delete" || action == "setpublic")) ||
+		(action == "setinterest" || action == "edit" || action == "delete" || action == "setpublic" || action == "setpin" ||
+			action == "addorder" || action == "removeorder")) ||
 		(itype == "user" && (action == "edit" || action == "setverified" || action == "verify" || action == "delete"))) {
 		log.Printf(" Disallowed action")
 		return
@@ -250,24 +298,41 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 			discussionNext := *d
 			discussionNext.Title = r.FormValue("title")
 			discussionNext.Description = r.FormValue("description")
-
-			//var possibleSlots []bool
-
-			if cur.IsAdmin {
+			if lengthString := r.FormValue("length"); lengthString != "" {
 				var err error
-				//possibleSlots, err = FormCheckToBool(r.Form["possible"])
+				discussionNext.Length, err = FormLength(lengthString)
 				if err != nil {
+					log.Printf("Parsing length: %v", err)
 					return
 				}
+			}
+
+			// nil leaves the possible slots as they are
+			var possibleSlots []event.SlotID
+			if r.FormValue("setpossible") == "true" {
+				slots, err := FormCheckToBool(r.Form["possible"])
+				if err != nil {
+					log.Printf("Parsing possible slots: %v", err)
+					return
+				}
+				// Non-nil even if empty, so that having no possible
+				// slots is refused rather than ignored
+				possibleSlots = append([]event.SlotID{}, slots...)
+			}
+
+			if cur.IsAdmin {
 				discussionNext.Owner = event.UserID(r.FormValue("owner"))
 			}
 
-			err := event.DiscussionUpdate(&discussionNext)
+			tags, err := event.TagsParse(r.FormValue("tags"))
+			if err == nil {
+				err = event.DiscussionEdit(&discussionNext, tags, possibleSlots)
+			}
 			if err != nil {
 				if event.IsValidationError(err) {
 					RenderTemplate(w, r, "edit", map[string]interface{}{
 						"Error":   err.Error(),
-						"Display": discussionNext,
+						"Display": DiscussionGetDisplay(&discussionNext, cur),
 					})
 					return
 				}
@@ -285,10 +350,80 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 			// Can't redirect to 'view' as it's been deleted
 			http.Redirect(w, r, "/list/discussion", http.StatusFound)
 			return
+		case "setpin":
+			// Only administrators can pin discussions
+			if !cur.IsAdmin {
+				log.Printf("%s isn't an admin", cur.Username)
+				return
+			}
+
+			var err error
+			if slotString := r.FormValue("slot"); slotString == "" {
+				err = event.DiscussionClearPin(d.DiscussionID)
+			} else {
+				var loc int
+				loc, err = strconv.Atoi(r.FormValue("location"))
+				if err != nil {
+					log.Printf("Parsing pin location: %v", err)
+					return
+				}
+				err = event.DiscussionSetPin(d.DiscussionID, event.SlotID(slotString), event.LocationID(loc))
+			}
+			if err != nil {
+				if event.IsValidationError(err) || err == event.ErrSlotNotFound ||
+					err == event.ErrLocationNotFound {
+					redirectURL = "view?flash=" + url.QueryEscape(err.Error())
+				} else {
+					panic(err)
+				}
+			}
+		case "addorder":
+			if !cur.MayEditDiscussion(d) {
+				log.Printf("WARNING user %s doesn't have permission to edit discussion %s",
+					cur.Username, d.DiscussionID)
+				return
+			}
+
+			// The other discussion must be one the user can see
+			other, _ := event.DiscussionFindById(event.DiscussionID(r.FormValue("other")))
+			if other == nil || !(other.IsPublic || cur.MayEditDiscussion(other)) {
+				log.Printf("Invalid discussion to order: %s", r.FormValue("other"))
+				return
+			}
+
+			before, after := d.DiscussionID, other.DiscussionID
+			if r.FormValue("relation") == "after" {
+				before, after = after, before
+			}
+			err := event.DiscussionOrderAdd(before, after, r.FormValue("sameday") == "true")
+			if err != nil {
+				if event.IsValidationError(err) {
+					redirectURL = "view?flash=" + url.QueryEscape(err.Error())
+				} else {
+					panic(err)
+				}
+			}
+		case "removeorder":
+			if !cur.MayEditDiscussion(d) {
+				log.Printf("WARNING user %s doesn't have permission to edit discussion %s",
+					cur.Username, d.DiscussionID)
+				return
+			}
+
+			before := event.DiscussionID(r.FormValue("before"))
+			after := event.DiscussionID(r.FormValue("after"))
+			if before != d.DiscussionID && after != d.DiscussionID {
+				log.Printf("Order %s before %s doesn't involve discussion %s",
+					before, after, d.DiscussionID)
+				return
+			}
+			if err := event.DiscussionOrderRemove(before, after); err != nil {
+				panic(err)
+			}
 		case "setpublic":
 			// Only administrators can change public
 			if !cur.IsAdmin {
-				log.Printf("%s isn't an admin")
+				log.Printf("%s isn't an admin", cur.Username)
 				return
 			}
 
@@ -331,6 +466,20 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 
 			err := event.UserUpdate(&userNext, cur, currentPassword, newPassword)
 
+			if err == nil && r.FormValue("setunavailable") == "true" {
+				var slots []event.SlotID
+				var days []event.DayID
+				slots, err = FormCheckToBool(r.Form["unavailable"])
+				if err == nil {
+					days, err = FormCheckToDays(r.Form["unavailableday"])
+				}
+				if err != nil {
+					log.Printf("Parsing unavailable slots: %v", err)
+					return
+				}
+				err = event.UserSetUnavailable(user.UserID, slots, days)
+			}
+
 			if err != nil {
 				if event.IsValidationError(err) {
 					RenderTemplate(w, r, "user/edit", map[string]interface{}{
@@ -344,7 +493,7 @@ func HandleUidPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		case "setverified":
 			// Only administrators can change verification status
 			if !cur.IsAdmin {
-				log.Printf("%s isn't an admin")
+				log.Printf("%s isn't an admin", cur.Username)
 				return
 			}
 
@@ -394,8 +543,16 @@ func HandleList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 	switch itype {
 	case "discussion":
-		templateArgs["List"] = DiscussionGetList(cur)
+		tag := r.URL.Query().Get("tag")
+		templateArgs["List"] = DiscussionGetList(cur, tag)
 		templateArgs["redirectURL"] = ""
+		templateArgs["Tag"] = tag
+		tracks, err := event.TrackGetAll()
+		if err != nil {
+			// Report error but continue
+			log.Printf("INTERNAL ERROR: Getting tags: %v", err)
+		}
+		templateArgs["Tracks"] = tracks
 	case "user":
 		templateArgs["List"] = UserGetUsersDisplay(cur)
 	default:
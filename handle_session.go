@@ -28,7 +28,7 @@ func HandleSessionNew(w http.ResponseWriter, r *http.Request, _ httprouter.Param
 func FindUser(username, password string) (*event.User, error) {
 	existingUser, err := event.UserFindByUsername(username)
 	if err != nil {
-		log.Printf("INTERNAL ERROR: UserFindByUsername: %v")
+		log.Printf("INTERNAL ERROR: UserFindByUsername: %v", err)
 		return nil, event.ErrInternal
 	}
 	if existingUser == nil {
@@ -55,12 +55,6 @@ func HandleUserCreate(w http.ResponseWriter, r *http.Request, _ httprouter.Param
 			goto fail
 		}
 		panic(err)
-		return
-	}
-
-	if err != nil {
-		panic(err)
-		return
 	}
 
 	// Create a new session
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"runtime/pprof"
+	"strconv"
 	"time"
 
 	"github.com/gwd/session-scheduler/event"
@@ -20,6 +21,15 @@ const (
 	ScheduleDebugVerbose = "EventScheduleDebugVerbose"
 	SearchAlgo           = "EventSearchAlgo"
 	SearchDuration       = "EventSearchDuration"
+	SearchIterations     = "EventSearchIterations"
+	SearchWorkers        = "EventSearchWorkers"
+	ExactLimit           = "EventExactLimit"
+	Incremental          = "EventScheduleIncremental"
+	ChurnWeight          = "EventChurnWeight"
+	TrackWeight          = "EventTrackWeight"
+	SearchObjective      = "EventSearchObjective"
+	FairnessWeight       = "EventFairnessWeight"
+	NormalizeInterest    = "EventNormalizeInterest"
 	Validate             = "EventValidate"
 	KeyDefaultLocation   = "EventDefaultLocation"
 	VerificationCode     = "ServeVerificationCode"
@@ -32,15 +42,25 @@ func main() {
 
 	kvs, err = keyvalue.OpenFile("data/serverconfig.sqlite")
 	if err != nil {
-		log.Fatal("Opening serverconfig: %v", err)
+		log.Fatalf("Opening serverconfig: %v", err)
 	}
 
 	adminPwd := flag.String("admin-password", "", "Set admin password")
 
 	flag.Var(kvs.GetFlagValue(KeyServeAddress), "address", "Address to serve http from")
 	flag.Var(kvs.GetFlagValue(ScheduleDebug), "sched-debug", "Debug level for logging (default 0)")
-	flag.Var(kvs.GetFlagValue(SearchAlgo), "searchalgo", "Search algorithm.  Options are heuristic, genetic, and random.")
+	flag.Var(kvs.GetFlagValue(SearchAlgo), "searchalgo", "Search algorithm.  Options are heuristic, genetic, random, annealing, and exact.")
 	flag.Var(kvs.GetFlagValue(SearchDuration), "searchtime", "Duration to run search")
+	flag.Var(kvs.GetFlagValue(SearchIterations), "searchiterations", "Number of iterations to run search for, instead of searchtime (0 to use searchtime)")
+	flag.Var(kvs.GetFlagValue(SearchWorkers), "searchworkers", "Number of searches to run in parallel (random, genetic and annealing only)")
+	flag.Int64Var(&OptSearchSeed, "seed", 0, "Seed for the search (default: chosen from the clock, and logged)")
+	flag.Var(kvs.GetFlagValue(ExactLimit), "exact-limit", "Maximum number of possible schedules for exact search")
+	flag.Var(kvs.GetFlagValue(Incremental), "incremental", "Reschedule incrementally, moving as few discussions as possible")
+	flag.Var(kvs.GetFlagValue(ChurnWeight), "churn-weight", "Penalty per affected person for moving a discussion when rescheduling incrementally")
+	flag.Var(kvs.GetFlagValue(TrackWeight), "track-weight", "Penalty for each pair of discussions from the same track in the same slot")
+	flag.Var(kvs.GetFlagValue(SearchObjective), "objective", "What to maximize.  Options are total (utility), maxmin (utility for the worst-off user), and blend.")
+	flag.Var(kvs.GetFlagValue(FairnessWeight), "fairness-weight", "For the blend objective, how much each percentage point for the worst-off user is worth")
+	flag.Var(kvs.GetFlagValue(NormalizeInterest), "normalize", "Scale each user's interest to the same total before searching, so everyone has the same weight")
 	flag.Var(kvs.GetFlagValue(Validate), "validate", "Extra validation of schedule consistency")
 	flag.Var(kvs.GetFlagValue(KeyDefaultLocation), "default-location", "Default location to use for times")
 
@@ -99,7 +119,11 @@ func main() {
 	case "serve":
 		serve()
 	case "schedule":
-		MakeSchedule(false)
+		schedule(flag.Args()[1:])
+	case "benchmark":
+		// Synthetic events have databases of their own
+		event.Close()
+		benchmark(flag.Args()[1:], locstring)
 	default:
 		log.Fatalf("Unknown command: %s", cmd)
 	}
@@ -109,7 +133,7 @@ func main() {
 func getSearchDuration() time.Duration {
 	durationString, err := kvs.Get(SearchDuration)
 	var duration time.Duration
-	if err != nil {
+	if err == nil {
 		duration, err = time.ParseDuration(durationString)
 	}
 
@@ -120,7 +144,9 @@ func getSearchDuration() time.Duration {
 	return duration
 }
 
-func MakeSchedule(async bool) error {
+// getSearchOptions returns the search options set in the server
+// config.
+func getSearchOptions(async bool) event.SearchOptions {
 	opt := event.SearchOptions{Async: async}
 
 	algostring, err := kvs.Get(SearchAlgo)
@@ -147,5 +173,61 @@ func MakeSchedule(async bool) error {
 
 	opt.SearchDuration = getSearchDuration()
 
-	return event.MakeSchedule(opt)
+	if limitString, err := kvs.Get(ExactLimit); err == nil {
+		opt.ExactLimit, err = strconv.ParseFloat(limitString, 64)
+		if err != nil {
+			log.Printf("Invalid exact search limit %s: %v", limitString, err)
+		}
+	}
+
+	if iterString, err := kvs.Get(SearchIterations); err == nil {
+		opt.MaxIterations, err = strconv.Atoi(iterString)
+		if err != nil {
+			log.Printf("Invalid search iterations %s: %v", iterString, err)
+		}
+	}
+
+	if workerString, err := kvs.Get(SearchWorkers); err == nil {
+		opt.Workers, err = strconv.Atoi(workerString)
+		if err != nil {
+			log.Printf("Invalid search workers %s: %v", workerString, err)
+		}
+	}
+
+	opt.Seed = OptSearchSeed
+
+	opt.Incremental = kvs.GetBoolDef(Incremental)
+
+	if weightString, err := kvs.Get(ChurnWeight); err == nil {
+		opt.ChurnWeight, err = strconv.Atoi(weightString)
+		if err != nil {
+			log.Printf("Invalid churn weight %s: %v", weightString, err)
+		}
+	}
+
+	if objective, err := kvs.Get(SearchObjective); err == nil {
+		opt.Objective = event.Objective(objective)
+	}
+
+	if weightString, err := kvs.Get(FairnessWeight); err == nil {
+		opt.FairnessWeight, err = strconv.Atoi(weightString)
+		if err != nil {
+			log.Printf("Invalid fairness weight %s: %v", weightString, err)
+		}
+	}
+
+	opt.Normalize = kvs.GetBoolDef(NormalizeInterest)
+
+	if weightString, err := kvs.Get(TrackWeight); err == nil {
+		opt.TrackWeight, err = strconv.Atoi(weightString)
+		if err != nil {
+			log.Printf("Invalid track weight %s: %v", weightString, err)
+		}
+	}
+
+	return opt
+}
+
+func MakeSchedule(async bool) error {
+	return event.MakeSchedule(getSearchOptions(async))
 }
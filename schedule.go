@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gwd/session-scheduler/event"
+)
+
+// schedule runs a search with the options in the server config.  In
+// a dry run, the result is printed rather than stored, so that search
+// options can be tried out on a live event.  The search options given
+// to schedule itself apply to this run only, and aren't saved.
+func schedule(args []string) {
+	fs := flag.NewFlagSet("schedule", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Print the schedule found instead of storing it")
+	format := fs.String("format", "text", "Output format for -dry-run: text or json")
+	diff := fs.Bool("diff", false, "For -dry-run, also list the changes from the stored schedule (json always includes them)")
+	algo := fs.String("searchalgo", "", "Search algorithm for this run only (default: from the server config)")
+	duration := fs.Duration("searchtime", 0, "Duration to run search for this run only (default: from the server config)")
+	fs.Parse(args)
+
+	opt := getSearchOptions(false)
+	if *algo != "" {
+		opt.Algo = event.SearchAlgo(*algo)
+	}
+	if *duration != 0 {
+		opt.SearchDuration = *duration
+		// A duration given here takes the place of any iteration
+		// count in the server config
+		opt.MaxIterations = 0
+	}
+
+	if !*dryRun {
+		if err := event.MakeSchedule(opt); err != nil {
+			log.Fatalf("Making schedule: %v", err)
+		}
+		return
+	}
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Unknown format %s", *format)
+	}
+
+	dr, err := event.MakeScheduleDryRun(opt)
+	if err != nil {
+		log.Fatalf("Making schedule: %v", err)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(dr); err != nil {
+			log.Fatalf("Encoding schedule: %v", err)
+		}
+		return
+	}
+
+	printDryRun(dr, *diff)
+}
+
+func printDryRun(dr *event.ScheduleDryRun, diff bool) {
+	fmt.Printf("Algorithm %s, seed %d, %d iterations\n", dr.Algo, dr.Seed, dr.Iterations)
+	fmt.Printf("Score %d (objective %s), worst-off user gets %d%%\n",
+		dr.Score, dr.Objective, dr.MinPercent)
+	for _, v := range dr.Violations {
+		fmt.Printf("Violation: %s\n", v)
+	}
+	for _, w := range dr.Warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+
+	for _, day := range dr.Timetable.Days {
+		fmt.Printf("\n%s\n", day.DayName)
+		for _, slot := range day.Slots {
+			if slot.IsBreak {
+				fmt.Printf("  %s  (break)\n", slot.Time)
+				continue
+			}
+			if len(slot.Discussions) == 0 {
+				fmt.Printf("  %s\n", slot.Time)
+			}
+			for i, disc := range slot.Discussions {
+				label := slot.Time
+				if i > 0 {
+					label = "     "
+				}
+				fmt.Printf("  %s  %s (%s, %d attending", label, disc.Title,
+					disc.LocationInfo.LocationName, disc.Attendees)
+				if disc.IsContinuation {
+					fmt.Printf(", continued from %s", disc.StartTime)
+				}
+				if disc.IsOverflow {
+					fmt.Printf(", overflows")
+				}
+				fmt.Printf(")\n")
+			}
+		}
+	}
+
+	if !diff {
+		return
+	}
+
+	fmt.Printf("\nChanges from the stored schedule:\n")
+	if len(dr.Diff.Moved)+len(dr.Diff.Added)+len(dr.Diff.Removed) == 0 {
+		fmt.Printf("  None\n")
+	}
+	for _, c := range dr.Diff.Moved {
+		fmt.Printf("  Moved %s: %s -> %s\n", c.Title, c.From, c.To)
+	}
+	for _, c := range dr.Diff.Added {
+		fmt.Printf("  Added %s: %s\n", c.Title, c.To)
+	}
+	for _, c := range dr.Diff.Removed {
+		fmt.Printf("  Removed %s: was %s\n", c.Title, c.From)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 
@@ -112,9 +113,11 @@ func serve() {
 	serveAddress, err := kvs.Get(KeyServeAddress)
 	switch {
 	case err == keyvalue.ErrNoRows:
-		// Generate a raw port between 1024 and 32768
+		// Generate a raw port between 1024 and 32768.  The global
+		// source is left unseeded, so use one of our own.
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 		serveAddress = fmt.Sprintf("localhost:%d",
-			rand.Int31n(32768-1024)+1024)
+			rng.Int31n(32768-1024)+1024)
 		if err := kvs.Set(KeyServeAddress, serveAddress); err != nil {
 			panic("Setting KeyServeAddress: " + err.Error())
 		}